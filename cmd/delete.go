@@ -2,13 +2,10 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
 
 	"github.com/charmbracelet/huh"
 	"github.com/chhlga/sh_adow/internal/config"
 	"github.com/chhlga/sh_adow/internal/repo"
-	"github.com/chhlga/sh_adow/internal/shadow"
 	"github.com/spf13/cobra"
 )
 
@@ -36,31 +33,26 @@ func runDelete(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	shadowPath, err := repo.ResolveShadowPath(filePath, cfg)
+	absPath, err := repo.CanonicalPath(filePath, cfg.FollowSymlinks)
 	if err != nil {
-		return fmt.Errorf("failed to resolve shadow path: %w", err)
+		return fmt.Errorf("failed to resolve path: %w", err)
 	}
 
-	list, err := shadow.LoadList(shadowPath)
+	shadowPath, err := repo.ResolveShadowPath(absPath, cfg)
 	if err != nil {
-		return fmt.Errorf("failed to load list: %w", err)
-	}
-
-	absPath, _ := filepath.Abs(filePath)
-	entry := list.FindFile(absPath)
-	if entry == nil {
-		return fmt.Errorf("file not tracked: %s", filePath)
+		return fmt.Errorf("failed to resolve shadow path: %w", err)
 	}
 
-	var version *shadow.Version
-	for i := range entry.Versions {
-		if entry.Versions[i].ID == versionID {
-			version = &entry.Versions[i]
-			break
-		}
+	// Ask before locking the repository exclusively so other saves are not
+	// held up, and look the version up again once it is locked.
+	store, err := openStore(shadowPath, cfg, false)
+	if err != nil {
+		return err
 	}
-	if version == nil {
-		return fmt.Errorf("version not found: %s", versionID)
+	_, _, version, err := loadVersion(store, cfg, absPath, filePath, versionID)
+	store.Close()
+	if err != nil {
+		return err
 	}
 
 	fmt.Printf("Version %s of %s\n", version.ID, filePath)
@@ -96,16 +88,27 @@ func runDelete(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	if !list.RemoveVersion(absPath, versionID) {
-		return fmt.Errorf("failed to remove version from list")
+	store, err = openStore(shadowPath, cfg, true)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	list, key, _, err := loadVersion(store, cfg, absPath, filePath, versionID)
+	if err != nil {
+		return err
+	}
+
+	garbage, err := store.Unlink(list, key, versionID)
+	if err != nil {
+		return fmt.Errorf("failed to remove version: %w", err)
 	}
 
-	if err := list.Save(shadowPath); err != nil {
+	if err := store.SaveList(list); err != nil {
 		return fmt.Errorf("failed to save list: %w", err)
 	}
 
-	snapshotPath := filepath.Join(shadowPath, "snapshots", versionID)
-	if err := os.Remove(snapshotPath); err != nil && !os.IsNotExist(err) {
+	if _, _, err := store.Release(list, garbage...); err != nil {
 		return fmt.Errorf("failed to delete snapshot: %w", err)
 	}
 
@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/chhlga/sh_adow/internal/config"
+	"github.com/chhlga/sh_adow/internal/diff"
+	"github.com/chhlga/sh_adow/internal/repo"
+	"github.com/chhlga/sh_adow/internal/shadow"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffStat       bool
+	diffContext    int
+	diffSideBySide bool
+	diffWordDiff   bool
+	diffRaw        bool
+	diffFollow     bool
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <file> [version-id] [version-id]",
+	Short: "Show changes between versions of a file",
+	Long: `Show the changes between two versions of a file as a unified diff.
+
+With no version the latest version is compared to the current file, with one
+version that version is compared to the current file, and with two the first
+is compared to the second.
+
+JSON and YAML files are compared key by key, so reordering keys and
+reformatting make no difference; use --raw to compare them as text.
+Files matching a diff driver of the config are converted to text first, or
+compared by an external tool; --raw skips those too.`,
+	Args: cobra.RangeArgs(1, 3),
+	RunE: runDiff,
+}
+
+func init() {
+	diffCmd.Flags().BoolVar(&diffStat, "stat", false, "Show a summary of changed lines instead of the diff")
+	diffCmd.Flags().IntVarP(&diffContext, "unified", "U", 3, "Number of context lines around each change")
+	diffCmd.Flags().BoolVarP(&diffSideBySide, "side-by-side", "y", false, "Show the versions next to each other")
+	diffCmd.Flags().BoolVar(&diffWordDiff, "word-diff", false, "Highlight changed words within lines")
+	diffCmd.Flags().BoolVar(&diffRaw, "raw", false, "Compare the content as text, without structure or diff drivers")
+	diffCmd.Flags().BoolVarP(&diffFollow, "follow", "L", false, "Use the target of a symlink instead of the link")
+	diffCmd.MarkFlagsMutuallyExclusive("stat", "side-by-side", "word-diff")
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	filePath := args[0]
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	absPath, err := repo.CanonicalPath(filePath, diffFollow || cfg.FollowSymlinks)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path: %w", err)
+	}
+
+	shadowPath, err := repo.ResolveShadowPath(absPath, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to resolve shadow path: %w", err)
+	}
+
+	store, err := openStore(shadowPath, cfg, false)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	list, key, err := loadFile(store, cfg, absPath)
+	if err != nil {
+		return fmt.Errorf("failed to load list: %w", err)
+	}
+	entry := list.FindFile(key)
+	if entry == nil || len(entry.Versions) == 0 {
+		return fmt.Errorf("file not tracked: %s", filePath)
+	}
+
+	ids := args[1:]
+	if len(ids) == 0 {
+		ids = []string{entry.Versions[0].ID}
+	}
+
+	var sides [2]diff.File
+	for i, id := range ids {
+		data, err := versionContent(store, entry, id)
+		if err != nil {
+			return err
+		}
+		sides[i] = diff.File{Label: filePath + "@" + id, Data: data}
+	}
+	if len(ids) == 1 {
+		data, err := currentContent(absPath)
+		if err != nil {
+			return err
+		}
+		sides[1] = diff.File{Label: filePath + " (current)", Data: data}
+		if info, err := os.Lstat(absPath); err == nil && info.Mode().IsRegular() {
+			sides[1].Path = absPath
+		}
+	}
+
+	if diffRaw {
+		return printDiff(os.Stdout, filePath, "", sides[0], sides[1])
+	}
+
+	driver, err := diff.FindDriver(diffDrivers(cfg.Diff), absPath)
+	if err != nil {
+		return err
+	}
+	if driver != nil {
+		return runDiffDriver(driver, filePath, sides)
+	}
+	return printDiff(os.Stdout, filePath, diff.Format(filePath), sides[0], sides[1])
+}
+
+// diffDrivers returns the diff drivers of the config, with the home
+// directory expanded in patterns matching whole paths.
+func diffDrivers(drivers []config.DiffDriver) []diff.Driver {
+	out := make([]diff.Driver, len(drivers))
+	for i, d := range drivers {
+		out[i] = diff.Driver{Pattern: d.Pattern, Textconv: d.Textconv, Command: d.Command}
+		if strings.Contains(d.Pattern, "/") {
+			out[i].Pattern = repo.ExpandPath(d.Pattern)
+		}
+	}
+	return out
+}
+
+// runDiffDriver compares the sides with driver, stopping it when
+// interrupted.
+func runDiffDriver(driver *diff.Driver, name string, sides [2]diff.File) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if driver.Command != "" {
+		return driver.Compare(ctx, name, sides, os.Stdin, os.Stdout, os.Stderr)
+	}
+	sides, err := driver.Convert(ctx, name, sides, os.Stderr)
+	if err != nil {
+		return err
+	}
+	return printDiff(os.Stdout, name, "", sides[0], sides[1])
+}
+
+// versionContent returns the content of a version, or the target of the
+// symlink it was saved from.
+func versionContent(store *shadow.Store, entry *shadow.FileEntry, id string) ([]byte, error) {
+	for _, v := range entry.Versions {
+		if v.ID == id && v.Link != "" {
+			return []byte(v.Link), nil
+		}
+	}
+
+	r, err := store.OpenVersion(entry, id)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read version %s: %w", id, err)
+	}
+	return data, nil
+}
+
+// currentContent returns the content of the file at path, the target of the
+// symlink at path, or nothing if it does not exist.
+func currentContent(path string) ([]byte, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		return []byte(target), err
+	}
+	return os.ReadFile(path)
+}
+
+// printDiff compares a and b, key by key if format is a structured one.
+func printDiff(w io.Writer, name, format string, a, b diff.File) error {
+	if diff.IsBinary(a.Data) || diff.IsBinary(b.Data) {
+		switch {
+		case string(a.Data) == string(b.Data):
+			fmt.Fprintln(w, "No differences")
+		case diffStat:
+			fmt.Fprintf(w, " %s | Bin %s -> %s\n", name, formatSize(int64(len(a.Data))), formatSize(int64(len(b.Data))))
+		default:
+			fmt.Fprintf(w, "Binary files %s and %s differ\n", a.Label, b.Label)
+		}
+		return nil
+	}
+
+	// The other renderings show text, so they compare text.
+	if format != "" && !diffStat && !diffSideBySide && !diffWordDiff {
+		changes, err := diff.Structure(a.Data, b.Data, format)
+		if err == nil {
+			printStructure(w, a.Label, b.Label, changes, string(a.Data) == string(b.Data))
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "Comparing as text, %s is not valid %s: %v\n", name, strings.ToUpper(format), err)
+	}
+
+	edits := diff.Lines(diff.SplitLines(a.Data), diff.SplitLines(b.Data))
+	inserted, deleted := diff.Stat(edits)
+	if inserted+deleted == 0 {
+		fmt.Fprintln(w, "No differences")
+		return nil
+	}
+
+	if diffStat {
+		printDiffStat(w, name, inserted, deleted)
+		return nil
+	}
+	hunks := diff.Hunks(edits, diffContext)
+	switch {
+	case diffSideBySide:
+		printSideBySide(w, a.Label, b.Label, hunks, terminalWidth())
+	case diffWordDiff:
+		printWordDiff(w, a.Label, b.Label, hunks, isatty.IsTerminal(os.Stdout.Fd()))
+	default:
+		printUnified(w, a.Label, b.Label, hunks)
+	}
+	return nil
+}
+
+// diffStatWidth bounds the bar of --stat.
+const diffStatWidth = 50
+
+func printDiffStat(w io.Writer, name string, inserted, deleted int) {
+	addStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	delStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+
+	plus, minus := inserted, deleted
+	if total := inserted + deleted; total > diffStatWidth {
+		plus = inserted * diffStatWidth / total
+		minus = deleted * diffStatWidth / total
+		// Keep every kind of change visible.
+		if inserted > 0 && plus == 0 {
+			plus = 1
+		}
+		if deleted > 0 && minus == 0 {
+			minus = 1
+		}
+	}
+
+	fmt.Fprintf(w, " %s | %d %s%s\n", name, inserted+deleted,
+		addStyle.Render(strings.Repeat("+", plus)), delStyle.Render(strings.Repeat("-", minus)))
+	fmt.Fprintf(w, " 1 file changed, %d %s(+), %d %s(-)\n",
+		inserted, plural(inserted, "insertion", "insertions"), deleted, plural(deleted, "deletion", "deletions"))
+}
+
+func printUnified(w io.Writer, oldLabel, newLabel string, hunks []diff.Hunk) {
+	// Tabs are part of the content, keep them as they are.
+	headerStyle := lipgloss.NewStyle().Bold(true).TabWidth(lipgloss.NoTabConversion)
+	hunkStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("6"))
+	addStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("2")).TabWidth(lipgloss.NoTabConversion)
+	delStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("1")).TabWidth(lipgloss.NoTabConversion)
+	noteStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+
+	fmt.Fprintln(w, headerStyle.Render("--- "+oldLabel))
+	fmt.Fprintln(w, headerStyle.Render("+++ "+newLabel))
+
+	for _, h := range hunks {
+		fmt.Fprintln(w, hunkStyle.Render(h.Header()))
+		for _, e := range h.Edits {
+			line := strings.TrimSuffix(e.Line, "\n")
+			switch e.Op {
+			case diff.Insert:
+				fmt.Fprintln(w, addStyle.Render("+"+line))
+			case diff.Delete:
+				fmt.Fprintln(w, delStyle.Render("-"+line))
+			default:
+				fmt.Fprintln(w, " "+line)
+			}
+			if !strings.HasSuffix(e.Line, "\n") {
+				fmt.Fprintln(w, noteStyle.Render(`\ No newline at end of file`))
+			}
+		}
+	}
+}
+
+func printStructure(w io.Writer, oldLabel, newLabel string, changes []diff.Change, same bool) {
+	if len(changes) == 0 {
+		if same {
+			fmt.Fprintln(w, "No differences")
+		} else {
+			fmt.Fprintln(w, "No differences in structure, only in formatting (use --raw to see them)")
+		}
+		return
+	}
+
+	headerStyle := lipgloss.NewStyle().Bold(true)
+	addStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	delStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	changeStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("3"))
+
+	fmt.Fprintln(w, headerStyle.Render("--- "+oldLabel))
+	fmt.Fprintln(w, headerStyle.Render("+++ "+newLabel))
+
+	for _, c := range changes {
+		path := c.Path
+		if path == "" {
+			path = "(root)"
+		}
+		switch c.Kind {
+		case diff.Added:
+			fmt.Fprintln(w, addStyle.Render(fmt.Sprintf("+ %s: %s", path, diff.FormatValue(c.New))))
+		case diff.Removed:
+			fmt.Fprintln(w, delStyle.Render(fmt.Sprintf("- %s: %s", path, diff.FormatValue(c.Old))))
+		case diff.Changed:
+			fmt.Fprintln(w, changeStyle.Render(fmt.Sprintf("~ %s: %s → %s", path, diff.FormatValue(c.Old), diff.FormatValue(c.New))))
+		}
+	}
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
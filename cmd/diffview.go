@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/term"
+	"github.com/chhlga/sh_adow/internal/diff"
+)
+
+// diffBlock is an unchanged line, or a run of deleted lines together with
+// the lines inserted in their place.
+type diffBlock struct {
+	context  string
+	deleted  []string
+	inserted []string
+}
+
+func (b diffBlock) changed() bool {
+	return len(b.deleted)+len(b.inserted) > 0
+}
+
+func diffBlocks(edits []diff.Edit) []diffBlock {
+	var blocks []diffBlock
+	for i := 0; i < len(edits); i++ {
+		e := edits[i]
+		if e.Op == diff.Equal {
+			blocks = append(blocks, diffBlock{context: e.Line})
+			continue
+		}
+		var b diffBlock
+		for ; i < len(edits) && edits[i].Op != diff.Equal; i++ {
+			if edits[i].Op == diff.Delete {
+				b.deleted = append(b.deleted, edits[i].Line)
+			} else {
+				b.inserted = append(b.inserted, edits[i].Line)
+			}
+		}
+		i--
+		blocks = append(blocks, b)
+	}
+	return blocks
+}
+
+// printWordDiff prints the changed lines of each hunk merged, with deleted
+// and inserted words highlighted. Without color they are marked [-like
+// this-] and {+like this+}.
+func printWordDiff(w io.Writer, oldLabel, newLabel string, hunks []diff.Hunk, color bool) {
+	headerStyle := lipgloss.NewStyle().Bold(true).TabWidth(lipgloss.NoTabConversion)
+	hunkStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("6"))
+	addStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("2")).TabWidth(lipgloss.NoTabConversion)
+	delStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("1")).TabWidth(lipgloss.NoTabConversion)
+
+	mark := func(op diff.Op, text string) string {
+		switch {
+		case color && op == diff.Insert:
+			return addStyle.Render(text)
+		case color:
+			return delStyle.Render(text)
+		case op == diff.Insert:
+			return "{+" + text + "+}"
+		}
+		return "[-" + text + "-]"
+	}
+
+	fmt.Fprintln(w, headerStyle.Render("--- "+oldLabel))
+	fmt.Fprintln(w, headerStyle.Render("+++ "+newLabel))
+
+	for _, h := range hunks {
+		fmt.Fprintln(w, hunkStyle.Render(h.Header()))
+		for _, b := range diffBlocks(h.Edits) {
+			if !b.changed() {
+				fmt.Fprintln(w, strings.TrimSuffix(b.context, "\n"))
+				continue
+			}
+
+			var line strings.Builder
+			for _, e := range diff.Words(strings.Join(b.deleted, ""), strings.Join(b.inserted, "")) {
+				for i, piece := range strings.Split(e.Line, "\n") {
+					if i > 0 {
+						fmt.Fprintln(w, line.String())
+						line.Reset()
+					}
+					if piece == "" {
+						continue
+					}
+					if e.Op == diff.Equal {
+						line.WriteString(piece)
+					} else {
+						line.WriteString(mark(e.Op, piece))
+					}
+				}
+			}
+			if line.Len() > 0 {
+				fmt.Fprintln(w, line.String())
+			}
+		}
+	}
+}
+
+// span is a piece of a line in a style.
+type span struct {
+	text  string
+	style lipgloss.Style
+}
+
+// printSideBySide prints the old and the new version in two columns that fit
+// width, wrapping long lines. The gutter marks changed lines with |, deleted
+// ones with < and inserted ones with >; within changed lines the changed
+// words are highlighted.
+func printSideBySide(w io.Writer, oldLabel, newLabel string, hunks []diff.Hunk, width int) {
+	headerStyle := lipgloss.NewStyle().Bold(true)
+	hunkStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("6"))
+	gutterStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	plainStyle := lipgloss.NewStyle()
+	addStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	delStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	addWordStyle := addStyle.Reverse(true)
+	delWordStyle := delStyle.Reverse(true)
+
+	col := max((width-3)/2, 10)
+	row := func(left []span, mark string, right []span) {
+		lrows, rrows := wrapSpans(left, col), wrapSpans(right, col)
+		for i := 0; i < max(len(lrows), len(rrows)); i++ {
+			var l, r []span
+			if i < len(lrows) {
+				l = lrows[i]
+			}
+			if i < len(rrows) {
+				r = rrows[i]
+			}
+			fmt.Fprintln(w, renderSpans(l, col)+gutterStyle.Render(" "+mark+" ")+renderSpans(r, 0))
+		}
+	}
+	line := func(text string, style lipgloss.Style) []span {
+		return []span{{text: text, style: style}}
+	}
+
+	row(line(oldLabel, headerStyle), " ", line(newLabel, headerStyle))
+	for _, h := range hunks {
+		fmt.Fprintln(w, hunkStyle.Render(h.Header()))
+		for _, b := range diffBlocks(h.Edits) {
+			if !b.changed() {
+				row(line(b.context, plainStyle), " ", line(b.context, plainStyle))
+				continue
+			}
+
+			for i := 0; i < max(len(b.deleted), len(b.inserted)); i++ {
+				switch {
+				case i >= len(b.inserted):
+					row(line(b.deleted[i], delStyle), "<", nil)
+				case i >= len(b.deleted):
+					row(nil, ">", line(b.inserted[i], addStyle))
+				default:
+					var left, right []span
+					for _, e := range diff.Words(b.deleted[i], b.inserted[i]) {
+						switch e.Op {
+						case diff.Equal:
+							left = append(left, span{e.Line, delStyle})
+							right = append(right, span{e.Line, addStyle})
+						case diff.Delete:
+							left = append(left, span{e.Line, delWordStyle})
+						case diff.Insert:
+							right = append(right, span{e.Line, addWordStyle})
+						}
+					}
+					row(left, "|", right)
+				}
+			}
+		}
+	}
+}
+
+var spanReplacer = strings.NewReplacer("\t", "    ", "\r", "", "\n", "")
+
+// wrapSpans breaks spans into rows of at most width columns.
+func wrapSpans(spans []span, width int) [][]span {
+	var rows [][]span
+	var cur []span
+	used := 0
+	for _, s := range spans {
+		var b strings.Builder
+		for _, r := range spanReplacer.Replace(s.text) {
+			rw := lipgloss.Width(string(r))
+			if used+rw > width && used > 0 {
+				if b.Len() > 0 {
+					cur = append(cur, span{b.String(), s.style})
+					b.Reset()
+				}
+				rows = append(rows, cur)
+				cur, used = nil, 0
+			}
+			b.WriteRune(r)
+			used += rw
+		}
+		if b.Len() > 0 {
+			cur = append(cur, span{b.String(), s.style})
+		}
+	}
+	if cur != nil || rows == nil {
+		rows = append(rows, cur)
+	}
+	return rows
+}
+
+// renderSpans renders a row, padded to width.
+func renderSpans(spans []span, width int) string {
+	var b strings.Builder
+	used := 0
+	for _, s := range spans {
+		b.WriteString(s.style.Render(s.text))
+		used += lipgloss.Width(s.text)
+	}
+	if used < width {
+		b.WriteString(strings.Repeat(" ", width-used))
+	}
+	return b.String()
+}
+
+// terminalWidth returns the width of the terminal on stdout or, when stdout
+// is not a terminal, the one COLUMNS names.
+func terminalWidth() int {
+	if width, _, err := term.GetSize(os.Stdout.Fd()); err == nil && width > 0 {
+		return width
+	}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return 80
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/chhlga/sh_adow/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fsckJSON    bool
+	fsckWorkers int
+)
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck [path]",
+	Short: "Verify the integrity of a repository",
+	Long: `Verify the repository for path (default: current directory): every version
+must have its snapshots, and every snapshot must match its hash and size.
+Snapshots no version references are reported as orphaned. Exits non-zero if
+any problem is found.`,
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	RunE:         runFsck,
+}
+
+func init() {
+	fsckCmd.Flags().BoolVar(&fsckJSON, "json", false, "Print the report as JSON")
+	fsckCmd.Flags().IntVarP(&fsckWorkers, "workers", "j", runtime.NumCPU(), "Number of snapshots verified in parallel")
+}
+
+func runFsck(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	shadowPath, err := resolveShadowPathArg(args, cfg)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(shadowPath, cfg, false)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := store.Fsck(fsckWorkers)
+	if err != nil {
+		return fmt.Errorf("failed to check repository: %w", err)
+	}
+
+	if fsckJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		problemStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+		for _, p := range report.Problems {
+			fmt.Println(problemStyle.Render("  ✗ " + p.String()))
+		}
+		fmt.Printf("Checked %d files, %d versions, %d snapshots\n", report.Files, report.Versions, report.Objects)
+	}
+
+	if !report.OK() {
+		return fmt.Errorf("found %d problems in %s", len(report.Problems), shadowPath)
+	}
+	if !fsckJSON {
+		fmt.Println("✓ No problems found")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/chhlga/sh_adow/internal/config"
+	"github.com/chhlga/sh_adow/internal/shadow"
+	"github.com/spf13/cobra"
+)
+
+var (
+	gcDryRun     bool
+	gcQuarantine bool
+	gcForce      bool
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc [path]",
+	Short: "Remove snapshots no version references",
+	Long: `Remove snapshots of the repository for path (default: current directory)
+that no version references anymore, along with temporary files left behind
+by interrupted commands. Repositories that cannot be locked, such as those in
+S3, are only collected with --force, as no other command may be running.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runGC,
+}
+
+func init() {
+	gcCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "Show what would be removed without removing it")
+	gcCmd.Flags().BoolVar(&gcQuarantine, "quarantine", false, "Move garbage to .shadow/quarantine/ instead of deleting it")
+	gcCmd.Flags().BoolVar(&gcForce, "force", false, "Collect garbage in a repository that cannot be locked")
+}
+
+func runGC(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	shadowPath, err := resolveShadowPathArg(args, cfg)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(shadowPath, cfg, !gcDryRun)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := store.GC(shadow.GCOptions{DryRun: gcDryRun, Quarantine: gcQuarantine, Force: gcForce})
+	if errors.Is(err, shadow.ErrNoLock) {
+		return fmt.Errorf("refusing to collect garbage, snapshots being saved would be removed: %w (use --force if no other command is running)", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to collect garbage: %w", err)
+	}
+
+	if len(report.Removed) == 0 {
+		fmt.Printf("✓ Nothing to collect (%d snapshots in use)\n", report.Reachable)
+		return nil
+	}
+
+	blobs, temps := 0, 0
+	for _, item := range report.Removed {
+		if item.Temp {
+			temps++
+		} else {
+			blobs++
+		}
+	}
+
+	if gcDryRun {
+		for _, item := range report.Removed {
+			fmt.Printf("  • %s (%s)\n", item.Name, formatSize(item.Size))
+		}
+		fmt.Printf("Would remove %d snapshots and %d temporary files, reclaiming %s\n",
+			blobs, temps, formatSize(report.Reclaimed))
+		return nil
+	}
+
+	if report.Quarantine != "" {
+		fmt.Printf("✓ Quarantined %d snapshots and %d temporary files (%s) in %s\n",
+			blobs, temps, formatSize(report.Reclaimed), report.Quarantine)
+		return nil
+	}
+	fmt.Printf("✓ Removed %d snapshots and %d temporary files, reclaiming %s\n",
+		blobs, temps, formatSize(report.Reclaimed))
+	return nil
+}
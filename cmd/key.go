@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	"github.com/chhlga/sh_adow/internal/config"
+	"github.com/chhlga/sh_adow/internal/repo"
+	"github.com/chhlga/sh_adow/internal/shadow"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keyNewKeyFile string
+)
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Manage repository encryption",
+}
+
+var keyInitCmd = &cobra.Command{
+	Use:   "init [path]",
+	Short: "Encrypt the repository with a passphrase or key file",
+	Long: `Encrypt all snapshots and metadata of the repository for path (default: current directory).
+The secret is read from key_file in the config, $SHADOW_PASSPHRASE, or prompted for.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runKeyInit,
+}
+
+var keyRotateCmd = &cobra.Command{
+	Use:   "rotate [path]",
+	Short: "Change the passphrase or key file of an encrypted repository",
+	Long: `Re-wrap the repository key with a new secret. Snapshots are not rewritten.
+The new secret is read from --new-key-file, $SHADOW_NEW_PASSPHRASE, or prompted for.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runKeyRotate,
+}
+
+func init() {
+	keyRotateCmd.Flags().StringVar(&keyNewKeyFile, "new-key-file", "", "Read the new secret from this file")
+	keyCmd.AddCommand(keyInitCmd)
+	keyCmd.AddCommand(keyRotateCmd)
+}
+
+func resolveShadowPathArg(args []string, cfg config.Config) (string, error) {
+	target := "."
+	if len(args) > 0 {
+		target = args[0]
+	}
+	shadowPath, err := repo.ResolveShadowPath(target, cfg)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve shadow path: %w", err)
+	}
+	return shadowPath, nil
+}
+
+func runKeyInit(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	shadowPath, err := resolveShadowPathArg(args, cfg)
+	if err != nil {
+		return err
+	}
+
+	backend, err := repo.OpenBackend(shadowPath, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	if err := shadow.CheckFormat(backend); err != nil {
+		return err
+	}
+
+	secret, err := readSecret(cfg.KeyFile, "SHADOW_PASSPHRASE", "Passphrase", true)
+	if err != nil {
+		return err
+	}
+
+	store := shadow.NewStore(backend)
+	if err := store.Lock(true, cfg.LockTimeout); err != nil {
+		return fmt.Errorf("failed to lock repository: %w", err)
+	}
+	defer store.Close()
+
+	encrypted, err := shadow.IsEncrypted(backend)
+	if err != nil {
+		return fmt.Errorf("failed to read repository key: %w", err)
+	}
+	if !encrypted {
+		// Bring older formats up to date first.
+		if _, err := store.Migrate(); err != nil {
+			return fmt.Errorf("failed to migrate repository: %w", err)
+		}
+	}
+
+	if _, err := shadow.EnableEncryption(backend, secret); err != nil {
+		return fmt.Errorf("failed to encrypt repository: %w", err)
+	}
+
+	fmt.Printf("✓ Encrypted repository %s\n", shadowPath)
+	return nil
+}
+
+func runKeyRotate(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	shadowPath, err := resolveShadowPathArg(args, cfg)
+	if err != nil {
+		return err
+	}
+
+	backend, err := repo.OpenBackend(shadowPath, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	if err := shadow.CheckFormat(backend); err != nil {
+		return err
+	}
+
+	if keyNewKeyFile == "" && cfg.KeyFile != "" {
+		return errors.New("repository uses key_file, pass the new one with --new-key-file")
+	}
+
+	secret, err := readSecret(cfg.KeyFile, "SHADOW_PASSPHRASE", "Current passphrase", false)
+	if err != nil {
+		return err
+	}
+
+	newSecret, err := readSecret(keyNewKeyFile, "SHADOW_NEW_PASSPHRASE", "New passphrase", true)
+	if err != nil {
+		return err
+	}
+
+	store := shadow.NewStore(backend)
+	if err := store.Lock(true, cfg.LockTimeout); err != nil {
+		return fmt.Errorf("failed to lock repository: %w", err)
+	}
+	defer store.Close()
+
+	enc, err := shadow.Unlock(backend, secret)
+	if err != nil {
+		return fmt.Errorf("failed to unlock repository: %w", err)
+	}
+
+	if err := enc.Rekey(newSecret); err != nil {
+		return fmt.Errorf("failed to rotate key: %w", err)
+	}
+
+	fmt.Printf("✓ Rotated key of %s\n", shadowPath)
+	if keyNewKeyFile != "" {
+		fmt.Printf("Update key_file in your config to %s\n", keyNewKeyFile)
+	}
+	return nil
+}
+
+// unlockBackend wraps backend for transparent decryption if the repository
+// is encrypted.
+func unlockBackend(backend shadow.Backend, cfg config.Config) (shadow.Backend, error) {
+	encrypted, err := shadow.IsEncrypted(backend)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repository key: %w", err)
+	}
+	if !encrypted {
+		return backend, nil
+	}
+
+	secret, err := readSecret(cfg.KeyFile, "SHADOW_PASSPHRASE", "Passphrase", false)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := shadow.Unlock(backend, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock repository: %w", err)
+	}
+	return enc, nil
+}
+
+// readSecret returns the contents of keyFile if set, otherwise the value of
+// the environment variable env, otherwise prompts for a passphrase.
+func readSecret(keyFile, env, title string, confirm bool) ([]byte, error) {
+	if keyFile != "" {
+		if strings.HasPrefix(keyFile, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			keyFile = filepath.Join(home, keyFile[2:])
+		}
+
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		data = bytes.TrimRight(data, "\r\n")
+		if len(data) == 0 {
+			return nil, fmt.Errorf("key file is empty: %s", keyFile)
+		}
+		return data, nil
+	}
+
+	if passphrase := os.Getenv(env); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	var passphrase, again string
+	fields := []huh.Field{
+		huh.NewInput().
+			Title(title).
+			EchoMode(huh.EchoModePassword).
+			Value(&passphrase),
+	}
+	if confirm {
+		fields = append(fields, huh.NewInput().
+			Title("Repeat passphrase").
+			EchoMode(huh.EchoModePassword).
+			Value(&again))
+	}
+
+	if err := huh.NewForm(huh.NewGroup(fields...)).Run(); err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	if confirm && passphrase != again {
+		return nil, errors.New("passphrases do not match")
+	}
+	return []byte(passphrase), nil
+}
@@ -3,7 +3,6 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/charmbracelet/lipgloss"
@@ -13,6 +12,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var listFollow bool
+
 var listCmd = &cobra.Command{
 	Use:   "list [file]",
 	Short: "List tracked files or versions of a specific file",
@@ -20,38 +21,54 @@ var listCmd = &cobra.Command{
 	RunE:  runList,
 }
 
+func init() {
+	listCmd.Flags().BoolVarP(&listFollow, "follow", "L", false, "Use the target of a symlink instead of the link")
+}
+
 func runList(cmd *cobra.Command, args []string) error {
 	cfg, err := config.Load()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	var shadowPath string
+	var shadowPath, absPath string
 	if len(args) == 0 {
 		wd, _ := os.Getwd()
 		shadowPath, err = repo.ResolveShadowPath(wd, cfg)
-	} else {
-		shadowPath, err = repo.ResolveShadowPath(args[0], cfg)
+	} else if absPath, err = repo.CanonicalPath(args[0], listFollow || cfg.FollowSymlinks); err == nil {
+		shadowPath, err = repo.ResolveShadowPath(absPath, cfg)
 	}
 	if err != nil {
 		return fmt.Errorf("failed to resolve shadow path: %w", err)
 	}
 
-	list, err := shadow.LoadList(shadowPath)
+	store, err := openStore(shadowPath, cfg, false)
 	if err != nil {
-		return fmt.Errorf("failed to load list: %w", err)
+		return err
 	}
+	defer store.Close()
 
 	if len(args) == 0 {
-		return listAllFiles(list, shadowPath)
+		index, err := store.LoadIndex()
+		if err != nil {
+			return fmt.Errorf("failed to load index: %w", err)
+		}
+		return listAllFiles(index, shadowPath)
 	}
 
-	absPath, _ := filepath.Abs(args[0])
-	return listFileVersions(list, absPath)
+	list, key, err := loadFile(store, cfg, absPath)
+	if err != nil {
+		return fmt.Errorf("failed to load list: %w", err)
+	}
+	policy, err := retentionPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	return listFileVersions(list, key, absPath, policy)
 }
 
-func listAllFiles(list *shadow.List, shadowPath string) error {
-	if len(list.Files) == 0 {
+func listAllFiles(index *shadow.Index, shadowPath string) error {
+	if len(index.Files) == 0 {
 		fmt.Println("No files tracked yet")
 		return nil
 	}
@@ -59,19 +76,28 @@ func listAllFiles(list *shadow.List, shadowPath string) error {
 	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("6"))
 	fmt.Println(headerStyle.Render(fmt.Sprintf("Files tracked in shadow (%s):", shadowPath)))
 
-	for _, file := range list.Files {
-		var totalSize int64
-		for _, v := range file.Versions {
-			totalSize += v.Size
-		}
-		fmt.Printf("  • %s (%d versions, %s)\n", file.Path, len(file.Versions), formatSize(totalSize))
+	for _, file := range index.Files {
+		fmt.Printf("  • %s (%d versions, %s, stored %s%s)\n", file.Path, file.Versions,
+			formatSize(file.Logical), formatSize(file.Stored), formatRatio(file.Stored, file.Logical))
 	}
 
+	// Paths may share blobs and chunks, which the index entries each count, so
+	// their sum is only an upper bound of the space taken.
+	var versions int
+	var logical, stored int64
+	for _, file := range index.Files {
+		versions += file.Versions
+		logical += file.Logical
+		stored += file.Stored
+	}
+	fmt.Printf("Total: %d versions, %s, stored at most %s%s\n", versions,
+		formatSize(logical), formatSize(stored), formatRatio(stored, logical))
+
 	return nil
 }
 
-func listFileVersions(list *shadow.List, filePath string) error {
-	entry := list.FindFile(filePath)
+func listFileVersions(list *shadow.List, key, filePath string, policy shadow.Retention) error {
+	entry := list.FindFile(key)
 	if entry == nil {
 		return fmt.Errorf("file not tracked: %s", filePath)
 	}
@@ -79,24 +105,48 @@ func listFileVersions(list *shadow.List, filePath string) error {
 	headerStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("6"))
 	virtualStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
 	versionStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("3"))
+	expiredStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
 
 	fmt.Println(headerStyle.Render(entry.Path))
 
-	stat, err := os.Stat(filePath)
-	if err == nil {
+	stat, err := os.Lstat(filePath)
+	if err == nil && stat.Mode()&os.ModeSymlink != 0 {
+		target, _ := os.Readlink(filePath)
+		fmt.Println(virtualStyle.Render(fmt.Sprintf("  → VIRTUAL HEAD (current: link to %s)", target)))
+	} else if err == nil {
 		fmt.Println(virtualStyle.Render(fmt.Sprintf("  → VIRTUAL HEAD (current: %s)", formatSize(stat.Size()))))
 	} else {
 		fmt.Println(virtualStyle.Render("  → VIRTUAL HEAD (file not found)"))
 	}
 
-	for _, v := range entry.Versions {
+	// Versions the retention rules expire are removed by the next prune.
+	expired := make(map[string]bool)
+	for _, id := range policy.Expired(entry, time.Now()) {
+		expired[id] = true
+	}
+	mark := " [expires]"
+	if policy.Thin {
+		mark = " [thinning]"
+	}
+
+	for i, v := range entry.Versions {
 		age := time.Since(v.CreatedAt)
 		tags := ""
 		if len(v.Tags) > 0 {
 			tags = fmt.Sprintf(" - \"%s\"", joinStrings(v.Tags, ", "))
 		}
-		fmt.Println(versionStyle.Render(fmt.Sprintf("  • %s - %s%s (%s)",
-			v.ID, formatDuration(age), tags, formatSize(v.Size))))
+		line := fmt.Sprintf("  • %s - %s%s (%s)", v.ID, formatDuration(age), tags, formatSize(v.Size))
+		if v.Link != "" {
+			line = fmt.Sprintf("  • %s - %s%s (link to %s)", v.ID, formatDuration(age), tags, v.Link)
+		}
+		if i+1 < len(entry.Versions) && v.MetaOnly(entry.Versions[i+1]) {
+			line += " [metadata only]"
+		}
+		if expired[v.ID] {
+			fmt.Println(expiredStyle.Render(line + mark))
+		} else {
+			fmt.Println(versionStyle.Render(line))
+		}
 		if v.Notes != "" {
 			fmt.Printf("    %s\n", v.Notes)
 		}
@@ -118,6 +168,13 @@ func formatSize(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
+func formatRatio(stored, total int64) string {
+	if total == 0 || stored == total {
+		return ""
+	}
+	return fmt.Sprintf(", %.0f%%", float64(stored)*100/float64(total))
+}
+
 func formatDuration(d time.Duration) string {
 	if d < time.Minute {
 		return "just now"
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/chhlga/sh_adow/internal/config"
+	"github.com/chhlga/sh_adow/internal/shadow"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateDryRun bool
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate [path]",
+	Short: "Upgrade a repository to the current format",
+	Long: `Upgrade the repository for path (default: current directory) to the current
+format. Other commands migrate automatically; use --dry-run to see what would
+change first.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runMigrate,
+}
+
+func init() {
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Show the migration steps without running them")
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	shadowPath, err := resolveShadowPathArg(args, cfg)
+	if err != nil {
+		return err
+	}
+
+	store, err := newStore(shadowPath, cfg)
+	if err != nil {
+		return err
+	}
+	if err := store.Lock(!migrateDryRun && store.NeedsMigrate(), cfg.LockTimeout); err != nil {
+		return fmt.Errorf("failed to lock repository: %w", err)
+	}
+	defer store.Close()
+
+	format, err := store.Format()
+	if err != nil {
+		return fmt.Errorf("failed to read repository format: %w", err)
+	}
+	steps, err := store.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		fmt.Printf("✓ Repository %s is up to date (format %d)\n", shadowPath, format)
+		return nil
+	}
+
+	if migrateDryRun {
+		fmt.Printf("Repository %s is in format %d, current is %d.\n", shadowPath, format, shadow.CurrentFormat)
+		fmt.Println("Metadata would be backed up, then migrated by:")
+		for _, m := range steps {
+			fmt.Printf("  • %d → %d: %s\n", m.From, m.To, m.Description)
+		}
+		return nil
+	}
+
+	done, err := store.Migrate()
+	for _, m := range done {
+		fmt.Printf("✓ %d → %d: %s\n", m.From, m.To, m.Description)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to migrate repository: %w", err)
+	}
+
+	fmt.Printf("✓ Migrated %s to format %d\n", shadowPath, shadow.CurrentFormat)
+	return nil
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+// progressMin is the smallest file a progress bar is shown for; smaller ones
+// are read before it would be noticed.
+const progressMin = 16 << 20
+
+// progressBar draws how much of a file has been read on stderr. It is nil,
+// and does nothing, when stderr is not a terminal or the file is small.
+type progressBar struct {
+	label string
+	total int64
+	drawn time.Time
+}
+
+func newProgressBar(label string, total int64) *progressBar {
+	if total < progressMin || !isatty.IsTerminal(os.Stderr.Fd()) {
+		return nil
+	}
+	return &progressBar{label: label, total: total}
+}
+
+// Update draws the bar for n bytes read, at most ten times a second.
+func (p *progressBar) Update(n int64) {
+	if p == nil || (time.Since(p.drawn) < 100*time.Millisecond && n < p.total) {
+		return
+	}
+	p.drawn = time.Now()
+
+	const width = 30
+	done := int(min(n, p.total) * width / p.total)
+	fmt.Fprintf(os.Stderr, "\r%s [%s%s] %3d%% %s / %s", p.label,
+		strings.Repeat("=", done), strings.Repeat(" ", width-done),
+		min(n, p.total)*100/p.total, formatSize(n), formatSize(p.total))
+}
+
+// Done clears the bar.
+func (p *progressBar) Done() {
+	if p == nil {
+		return
+	}
+	fmt.Fprint(os.Stderr, "\r\033[K")
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chhlga/sh_adow/internal/config"
+	"github.com/chhlga/sh_adow/internal/shadow"
+	"github.com/spf13/cobra"
+)
+
+var pruneDryRun bool
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune [path]",
+	Short: "Remove versions expired by the retention rules",
+	Long: `Remove the versions of every file in the repository for path (default:
+current directory) that the retention rules of the config expire. Versions
+with a protected tag and the newest version of each file are always kept.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runPrune,
+}
+
+func init() {
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Show what would be removed without removing it")
+}
+
+func runPrune(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	policy, err := retentionPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	if !policy.Enabled() {
+		return errors.New("no retention rules configured")
+	}
+
+	shadowPath, err := resolveShadowPathArg(args, cfg)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(shadowPath, cfg, !pruneDryRun)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	list, err := store.LoadList()
+	if err != nil {
+		return fmt.Errorf("failed to load list: %w", err)
+	}
+
+	now := time.Now()
+	var garbage []string
+	var pruned []shadow.Version
+	files := 0
+	paths := make([]string, len(list.Files))
+	for i, f := range list.Files {
+		paths[i] = f.Path
+	}
+	for _, path := range paths {
+		removed, keys, err := store.Prune(list, path, policy, now, pruneDryRun)
+		if err != nil {
+			return fmt.Errorf("failed to prune %s: %w", path, err)
+		}
+		if len(removed) == 0 {
+			continue
+		}
+
+		fmt.Println(path)
+		for _, v := range removed {
+			fmt.Printf("  • %s  %s  %s\n", v.ID, v.CreatedAt.Format("2006-01-02 15:04:05"), formatSize(v.Size))
+		}
+		garbage = append(garbage, keys...)
+		pruned = append(pruned, removed...)
+		files++
+	}
+
+	if len(pruned) == 0 {
+		fmt.Println("✓ Nothing to prune")
+		return nil
+	}
+	if pruneDryRun {
+		// Versions stored as deltas of removed ones are stored anew, so this
+		// is only an estimate.
+		fmt.Printf("Would remove %d versions of %d files, freeing about %s\n",
+			len(pruned), files, formatSize(list.Freed(pruned)))
+		return nil
+	}
+
+	if err := store.SaveList(list); err != nil {
+		return fmt.Errorf("failed to save list: %w", err)
+	}
+
+	_, freed, err := store.Release(list, garbage...)
+	if err != nil {
+		return fmt.Errorf("failed to release snapshots: %w", err)
+	}
+
+	fmt.Printf("✓ Removed %d versions of %d files, freeing %s\n", len(pruned), files, formatSize(freed))
+	return nil
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/chhlga/sh_adow/internal/config"
+	"github.com/chhlga/sh_adow/internal/repo"
+	"github.com/chhlga/sh_adow/internal/shadow"
+	"github.com/spf13/cobra"
+)
+
+var (
+	repairDryRun      bool
+	repairJSON        bool
+	repairRecoverTo   string
+	repairListBackups bool
+	repairFromBackup  string
+)
+
+var repairCmd = &cobra.Command{
+	Use:   "repair [path]",
+	Short: "Rebuild damaged repository metadata from snapshots",
+	Long: `Rebuild the metadata of the repository for path (default: current directory).
+Versions are salvaged from damaged metadata, versions whose snapshots are gone
+are dropped, and snapshots no version references are recovered as versions
+of --recover-to. The metadata is backed up first.
+
+With --list-backups or --from-backup, list the metadata backups of the
+repository or roll the metadata back to one of them instead.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runRepair,
+}
+
+func init() {
+	repairCmd.Flags().BoolVar(&repairDryRun, "dry-run", false, "Show what would be repaired without changing anything")
+	repairCmd.Flags().BoolVar(&repairJSON, "json", false, "Print the report as JSON")
+	repairCmd.Flags().StringVar(&repairRecoverTo, "recover-to", "unknown", "Path to recover orphaned snapshots as versions of")
+	repairCmd.Flags().BoolVar(&repairListBackups, "list-backups", false, "List metadata backups")
+	repairCmd.Flags().StringVar(&repairFromBackup, "from-backup", "", "Roll metadata back to the named backup")
+}
+
+func runRepair(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	shadowPath, err := resolveShadowPathArg(args, cfg)
+	if err != nil {
+		return err
+	}
+
+	// Repairing must not depend on the metadata being readable, so the
+	// repository is neither migrated nor backed up automatically here.
+	store, err := newStore(shadowPath, cfg)
+	if err != nil {
+		return err
+	}
+	if err := store.Lock(!repairDryRun && !repairListBackups, cfg.LockTimeout); err != nil {
+		return fmt.Errorf("failed to lock repository: %w", err)
+	}
+	defer store.Close()
+
+	if repairListBackups {
+		return listBackups(store)
+	}
+
+	if repairFromBackup != "" {
+		if repairDryRun {
+			return errors.New("--dry-run cannot be combined with --from-backup")
+		}
+		if err := store.RestoreBackup(repairFromBackup); err != nil {
+			return fmt.Errorf("failed to restore backup: %w", err)
+		}
+		fmt.Printf("✓ Restored metadata from backup %s\n", repairFromBackup)
+		return nil
+	}
+
+	recoverTo, err := repo.CanonicalPath(repairRecoverTo, false)
+	if err != nil {
+		return err
+	}
+
+	report, err := store.Repair(shadow.RepairOptions{Path: repo.StoredPath(recoverTo), DryRun: repairDryRun})
+	if err != nil {
+		return fmt.Errorf("failed to repair repository: %w", err)
+	}
+
+	if repairJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	for _, name := range report.Damaged {
+		fmt.Printf("  • damaged %s\n", name)
+	}
+	for _, p := range report.Dropped {
+		fmt.Printf("  • dropped %s\n", p)
+	}
+	for _, name := range report.Unreadable {
+		fmt.Printf("  • unreadable snapshot %s\n", name)
+	}
+
+	verb := "Rebuilt"
+	if repairDryRun {
+		verb = "Would rebuild"
+	}
+	fmt.Printf("%s metadata for %d files, %d versions (%d dropped, %d recovered as %s)\n",
+		verb, report.Files, report.Versions, len(report.Dropped), report.Recovered, recoverTo)
+	if report.Backup != "" {
+		fmt.Printf("  Previous metadata saved as backup %s\n", report.Backup)
+	}
+	return nil
+}
+
+func listBackups(store *shadow.Store) error {
+	backups, err := store.Backups()
+	if err != nil {
+		return fmt.Errorf("failed to load backups: %w", err)
+	}
+	if len(backups) == 0 {
+		fmt.Println("No metadata backups yet")
+		return nil
+	}
+
+	for _, b := range backups {
+		fmt.Printf("  • %s (%s, %d documents)\n", b.Name, b.Created.Local().Format("2006-01-02 15:04:05"), len(b.Documents))
+	}
+	return nil
+}
@@ -1,10 +1,10 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
-	"time"
 
 	"github.com/charmbracelet/huh"
 	"github.com/chhlga/sh_adow/internal/config"
@@ -14,7 +14,11 @@ import (
 )
 
 var (
-	restoreNoSave bool
+	restoreNoSave  bool
+	restoreNoMeta  bool
+	restoreNoOwner bool
+	restoreForce   bool
+	restoreFollow  bool
 )
 
 var restoreCmd = &cobra.Command{
@@ -26,6 +30,10 @@ var restoreCmd = &cobra.Command{
 
 func init() {
 	restoreCmd.Flags().BoolVar(&restoreNoSave, "no-save", false, "Don't save current state before restoring")
+	restoreCmd.Flags().BoolVar(&restoreNoMeta, "no-meta", false, "Don't restore mode, owner, modification time and extended attributes")
+	restoreCmd.Flags().BoolVar(&restoreNoOwner, "no-owner", false, "Don't restore the owner")
+	restoreCmd.Flags().BoolVar(&restoreForce, "force", false, "Restore even if the snapshot does not match its recorded hash")
+	restoreCmd.Flags().BoolVarP(&restoreFollow, "follow", "L", false, "Use the target of a symlink instead of the link")
 }
 
 func runRestore(cmd *cobra.Command, args []string) error {
@@ -37,31 +45,34 @@ func runRestore(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	shadowPath, err := repo.ResolveShadowPath(filePath, cfg)
+	follow := restoreFollow || cfg.FollowSymlinks
+	absPath, err := repo.CanonicalPath(filePath, follow)
 	if err != nil {
-		return fmt.Errorf("failed to resolve shadow path: %w", err)
+		return fmt.Errorf("failed to resolve path: %w", err)
 	}
 
-	list, err := shadow.LoadList(shadowPath)
-	if err != nil {
-		return fmt.Errorf("failed to load list: %w", err)
+	// When following, the history is that of the target, which is what gets
+	// saved and replaced; the link itself stays.
+	dst := filePath
+	if follow {
+		dst = absPath
 	}
 
-	absPath, _ := filepath.Abs(filePath)
-	entry := list.FindFile(absPath)
-	if entry == nil {
-		return fmt.Errorf("file not tracked: %s", filePath)
+	shadowPath, err := repo.ResolveShadowPath(absPath, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to resolve shadow path: %w", err)
 	}
 
-	var version *shadow.Version
-	for i := range entry.Versions {
-		if entry.Versions[i].ID == versionID {
-			version = &entry.Versions[i]
-			break
-		}
+	// Ask before locking the repository exclusively so other saves are not
+	// held up, and look the version up again once it is locked.
+	store, err := openStore(shadowPath, cfg, false)
+	if err != nil {
+		return err
 	}
-	if version == nil {
-		return fmt.Errorf("version not found: %s", versionID)
+	_, _, _, err = loadVersion(store, cfg, absPath, filePath, versionID)
+	store.Close()
+	if err != nil {
+		return err
 	}
 
 	var saveFirst bool
@@ -79,39 +90,51 @@ func runRestore(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	if saveFirst {
-		if _, err := os.Stat(filePath); err == nil {
-			content, _ := os.ReadFile(filePath)
-			newVersionID := shadow.GenerateVersionID(content)
+	store, err = openStore(shadowPath, cfg, true)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
 
-			snapshotPath := filepath.Join(shadowPath, "snapshots", newVersionID)
-			if err := shadow.CopyFile(filePath, snapshotPath); err != nil {
+	list, key, _, err := loadVersion(store, cfg, absPath, filePath, versionID)
+	if err != nil {
+		return err
+	}
+
+	if saveFirst {
+		if _, err := os.Lstat(dst); err == nil {
+			newVersion, err := snapshot(context.Background(), store, dst, cfg, follow)
+			if err != nil {
 				return fmt.Errorf("failed to save current state: %w", err)
 			}
 
-			fileHash, _ := shadow.HashFile(filePath)
-			stat, _ := os.Stat(filePath)
+			newVersionID := list.NewVersionID()
+			newVersion.ID = newVersionID
+			newVersion.Tags = []string{"auto-save"}
+			newVersion.Notes = "Saved before restore"
 
-			newVersion := shadow.Version{
-				ID:        newVersionID,
-				CreatedAt: time.Now(),
-				Tags:      []string{"auto-save"},
-				Notes:     "Saved before restore",
-				Size:      stat.Size(),
-				Hash:      fileHash,
+			list.AddVersion(key, newVersion)
+			garbage, err := store.Pack(list, key)
+			if err != nil {
+				return fmt.Errorf("failed to pack versions: %w", err)
 			}
-
-			list.AddVersion(absPath, newVersion)
-			if err := list.Save(shadowPath); err != nil {
+			if err := store.SaveList(list); err != nil {
 				return fmt.Errorf("failed to save list: %w", err)
 			}
+			if _, _, err := store.Release(list, garbage...); err != nil {
+				return fmt.Errorf("failed to release snapshots: %w", err)
+			}
 
 			fmt.Printf("✓ Saved current state as %s\n", newVersionID)
 		}
 	}
 
-	snapshotPath := filepath.Join(shadowPath, "snapshots", versionID)
-	if err := shadow.CopyFile(snapshotPath, filePath); err != nil {
+	opts := shadow.RestoreOptions{Meta: !restoreNoMeta, Owner: !restoreNoOwner, Force: restoreForce}
+	err = store.Restore(list.FindFile(key), versionID, dst, opts)
+	if errors.Is(err, shadow.ErrCorrupt) {
+		return fmt.Errorf("refusing to restore %s, %s was left untouched: %w (use --force to restore it anyway)", versionID, filePath, err)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to restore file: %w", err)
 	}
 
@@ -25,4 +25,12 @@ func init() {
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(restoreCmd)
 	rootCmd.AddCommand(deleteCmd)
+	rootCmd.AddCommand(keyCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(fsckCmd)
+	rootCmd.AddCommand(gcCmd)
+	rootCmd.AddCommand(repairCmd)
+	rootCmd.AddCommand(pruneCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(showCmd)
 }
@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"time"
 
@@ -14,8 +17,9 @@ import (
 )
 
 var (
-	saveTags  []string
-	saveNotes string
+	saveTags   []string
+	saveNotes  string
+	saveFollow bool
 )
 
 var saveCmd = &cobra.Command{
@@ -28,48 +32,17 @@ var saveCmd = &cobra.Command{
 func init() {
 	saveCmd.Flags().StringSliceVarP(&saveTags, "tag", "t", []string{}, "Tags for this version")
 	saveCmd.Flags().StringVarP(&saveNotes, "note", "n", "", "Notes for this version")
+	saveCmd.Flags().BoolVarP(&saveFollow, "follow", "L", false, "Save the target of a symlink instead of the link")
 }
 
 func runSave(cmd *cobra.Command, args []string) error {
 	filePath := args[0]
 
-	if _, err := os.Stat(filePath); err != nil {
+	if _, err := os.Lstat(filePath); err != nil {
 		return fmt.Errorf("file not found: %s", filePath)
 	}
 
-	cfg, err := config.Load()
-	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
-	}
-
-	shadowPath, err := repo.ResolveShadowPath(filePath, cfg)
-	if err != nil {
-		return fmt.Errorf("failed to resolve shadow path: %w", err)
-	}
-
-	if err := repo.EnsureShadowDir(shadowPath); err != nil {
-		return fmt.Errorf("failed to create shadow directory: %w", err)
-	}
-
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
-	}
-
-	versionID := shadow.GenerateVersionID(content)
-
-	snapshotPath := filepath.Join(shadowPath, "snapshots", versionID)
-	if err := shadow.CopyFile(filePath, snapshotPath); err != nil {
-		return fmt.Errorf("failed to copy file: %w", err)
-	}
-
-	fileHash, err := shadow.HashFile(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to hash file: %w", err)
-	}
-
-	stat, _ := os.Stat(filePath)
-
+	// Ask before locking the repository so other saves are not held up.
 	if len(saveTags) == 0 && saveNotes == "" {
 		var tagsInput string
 		form := huh.NewForm(
@@ -92,31 +65,137 @@ func runSave(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	version := shadow.Version{
-		ID:        versionID,
-		CreatedAt: time.Now(),
-		Tags:      saveTags,
-		Notes:     saveNotes,
-		Size:      stat.Size(),
-		Hash:      fileHash,
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	follow := saveFollow || cfg.FollowSymlinks
+	absPath, err := repo.CanonicalPath(filePath, follow)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path: %w", err)
+	}
+
+	shadowPath, err := repo.ResolveShadowPath(absPath, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to resolve shadow path: %w", err)
+	}
+
+	store, err := openStore(shadowPath, cfg, true)
+	if err != nil {
+		return err
 	}
+	defer store.Close()
 
-	list, err := shadow.LoadList(shadowPath)
+	list, key, err := loadFile(store, cfg, absPath)
 	if err != nil {
 		return fmt.Errorf("failed to load list: %w", err)
 	}
 
-	absPath, _ := filepath.Abs(filePath)
-	list.AddVersion(absPath, version)
+	// An interrupt stops reading the file; once it is stored, the metadata
+	// is written regardless so that the repository stays consistent.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	version, err := snapshot(ctx, store, filePath, cfg, follow)
+	if errors.Is(err, context.Canceled) {
+		return errors.New("save interrupted, nothing was saved")
+	}
+	if err != nil {
+		return err
+	}
+	versionID := list.NewVersionID()
+	version.ID = versionID
+	version.Tags = saveTags
+	version.Notes = saveNotes
+
+	list.AddVersion(key, version)
 
-	if err := list.Save(shadowPath); err != nil {
+	garbage, err := store.Pack(list, key)
+	if err != nil {
+		return fmt.Errorf("failed to pack versions: %w", err)
+	}
+
+	var pruned []shadow.Version
+	if cfg.Retention.PruneOnSave {
+		policy, err := retentionPolicy(cfg)
+		if err != nil {
+			return err
+		}
+		var keys []string
+		pruned, keys, err = store.Prune(list, key, policy, time.Now(), false)
+		if err != nil {
+			return fmt.Errorf("failed to prune versions: %w", err)
+		}
+		garbage = append(garbage, keys...)
+	}
+
+	if err := store.SaveList(list); err != nil {
 		return fmt.Errorf("failed to save list: %w", err)
 	}
 
+	if _, _, err := store.Release(list, garbage...); err != nil {
+		return fmt.Errorf("failed to release snapshots: %w", err)
+	}
+
 	fmt.Printf("✓ Saved version %s of %s\n", versionID, filePath)
+	if len(pruned) > 0 {
+		fmt.Printf("✓ Pruned %d old versions\n", len(pruned))
+	}
 	return nil
 }
 
+// snapshot stores the content of the file at filePath and returns a version
+// describing it, without ID, tags or notes. A symlink is stored as a link
+// unless follow is set. Large files show a progress bar while they are read.
+func snapshot(ctx context.Context, store *shadow.Store, filePath string, cfg config.Config, follow bool) (shadow.Version, error) {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return shadow.Version{}, err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 && !follow {
+		blob, target, err := store.PutLink(filePath)
+		if err != nil {
+			return shadow.Version{}, fmt.Errorf("failed to store link: %w", err)
+		}
+		return shadow.Version{
+			CreatedAt:  time.Now(),
+			Size:       blob.Size,
+			Hash:       blob.Hash,
+			StoredSize: blob.StoredSize,
+			Codec:      blob.Codec,
+			Link:       target,
+		}, nil
+	}
+
+	meta, err := shadow.ReadMeta(filePath, cfg.Xattrs)
+	if err != nil {
+		return shadow.Version{}, fmt.Errorf("failed to read file metadata: %w", err)
+	}
+
+	var size int64
+	if stat, err := os.Stat(filePath); err == nil {
+		size = stat.Size()
+	}
+	bar := newProgressBar("Saving "+filepath.Base(filePath), size)
+	blob, err := store.PutContext(ctx, filePath, bar.Update)
+	bar.Done()
+	if err != nil {
+		return shadow.Version{}, fmt.Errorf("failed to store snapshot: %w", err)
+	}
+
+	return shadow.Version{
+		CreatedAt:  time.Now(),
+		Size:       blob.Size,
+		Hash:       blob.Hash,
+		StoredSize: blob.StoredSize,
+		Codec:      blob.Codec,
+		Chunks:     blob.Chunks,
+		Meta:       meta,
+	}, nil
+}
+
 func splitTags(input string) []string {
 	var tags []string
 	for _, tag := range splitByComma(input) {
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chhlga/sh_adow/internal/config"
+	"github.com/chhlga/sh_adow/internal/repo"
+	"github.com/chhlga/sh_adow/internal/shadow"
+	"github.com/spf13/cobra"
+)
+
+var (
+	showMeta   bool
+	showOutput string
+	showFollow bool
+)
+
+var showCmd = &cobra.Command{
+	Use:     "show <file> [version-id]",
+	Aliases: []string{"cat"},
+	Short:   "Print a version of a file without restoring it",
+	Long: `Print the content of a version of a file, by default the latest, or its
+metadata with --meta. With --output the version is written to another path
+instead, along with its mode and modification time. Neither the tracked file
+nor the repository is changed.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runShow,
+}
+
+func init() {
+	showCmd.Flags().BoolVar(&showMeta, "meta", false, "Print the metadata of the version instead of its content")
+	showCmd.Flags().StringVarP(&showOutput, "output", "o", "", "Write the version to this path instead of stdout")
+	showCmd.Flags().BoolVarP(&showFollow, "follow", "L", false, "Use the target of a symlink instead of the link")
+}
+
+func runShow(cmd *cobra.Command, args []string) error {
+	filePath := args[0]
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	absPath, err := repo.CanonicalPath(filePath, showFollow || cfg.FollowSymlinks)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path: %w", err)
+	}
+
+	shadowPath, err := repo.ResolveShadowPath(absPath, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to resolve shadow path: %w", err)
+	}
+
+	store, err := openStore(shadowPath, cfg, false)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	list, key, err := loadFile(store, cfg, absPath)
+	if err != nil {
+		return fmt.Errorf("failed to load list: %w", err)
+	}
+	entry := list.FindFile(key)
+	if entry == nil || len(entry.Versions) == 0 {
+		return fmt.Errorf("file not tracked: %s", filePath)
+	}
+
+	version := &entry.Versions[0]
+	if len(args) > 1 {
+		version = nil
+		for i := range entry.Versions {
+			if entry.Versions[i].ID == args[1] {
+				version = &entry.Versions[i]
+				break
+			}
+		}
+		if version == nil {
+			return fmt.Errorf("version not found: %s", args[1])
+		}
+	}
+
+	if showOutput != "" {
+		output, err := repo.CanonicalPath(showOutput, false)
+		if err != nil {
+			return fmt.Errorf("failed to resolve output path: %w", err)
+		}
+		if output == absPath {
+			return fmt.Errorf("%s is the tracked file, use restore to replace it", showOutput)
+		}
+		if err := store.Restore(entry, version.ID, output, shadow.RestoreOptions{Meta: true}); err != nil {
+			return fmt.Errorf("failed to write version: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "✓ Wrote version %s of %s to %s\n", version.ID, filePath, showOutput)
+	}
+
+	if showMeta {
+		printVersionMeta(*version)
+		return nil
+	}
+	if showOutput != "" {
+		return nil
+	}
+
+	if version.Link != "" {
+		fmt.Println(version.Link)
+		return nil
+	}
+
+	r, err := store.OpenVersion(entry, version.ID)
+	if err != nil {
+		return fmt.Errorf("failed to open version: %w", err)
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(os.Stdout, io.TeeReader(r, hash)); err != nil {
+		return fmt.Errorf("failed to read version: %w", err)
+	}
+	// The content is already out, but a corrupt one must not go unnoticed.
+	if got := hex.EncodeToString(hash.Sum(nil)); version.Hash != "" && got != version.Hash {
+		return fmt.Errorf("%w: version %s has content hash %s, expected %s", shadow.ErrCorrupt, version.ID, got, version.Hash)
+	}
+	return nil
+}
+
+func printVersionMeta(v shadow.Version) {
+	field := func(name, value string) {
+		fmt.Printf("%-10s %s\n", name+":", value)
+	}
+
+	field("Version", v.ID)
+	field("Created", v.CreatedAt.Local().Format(time.RFC3339))
+	if len(v.Tags) > 0 {
+		field("Tags", strings.Join(v.Tags, ", "))
+	}
+	if v.Notes != "" {
+		field("Notes", v.Notes)
+	}
+	if v.Link != "" {
+		field("Link", v.Link)
+	}
+	field("Size", formatSize(v.Size))
+	field("Hash", v.Hash)
+
+	stored := formatSize(v.StoredSize)
+	switch {
+	case len(v.Chunks) > 0:
+		stored += fmt.Sprintf(", %d chunks", len(v.Chunks))
+	case v.DeltaBase != "":
+		stored += ", delta against " + v.DeltaBase
+	}
+	if v.Codec != "" {
+		stored += ", " + v.Codec
+	}
+	field("Stored", stored)
+
+	if v.Meta == nil {
+		return
+	}
+	field("Mode", v.Meta.Mode.String())
+	field("Modified", v.Meta.ModTime.Local().Format(time.RFC3339))
+	field("Owner", fmt.Sprintf("%d:%d", v.Meta.UID, v.Meta.GID))
+	if len(v.Meta.Xattrs) > 0 {
+		names := make([]string, 0, len(v.Meta.Xattrs))
+		for name := range v.Meta.Xattrs {
+			names = append(names, fmt.Sprintf("%s (%s)", name, formatSize(int64(len(v.Meta.Xattrs[name])))))
+		}
+		sort.Strings(names)
+		field("Xattrs", strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/chhlga/sh_adow/internal/config"
+	"github.com/chhlga/sh_adow/internal/repo"
+	"github.com/chhlga/sh_adow/internal/shadow"
+)
+
+// newStore opens the repository at shadowPath, unlocking it if it is
+// encrypted, without locking or migrating it.
+func newStore(shadowPath string, cfg config.Config) (*shadow.Store, error) {
+	codec, err := shadow.ParseCodec(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := shadow.ParseMode(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, err := repo.OpenBackend(shadowPath, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	if err := shadow.CheckFormat(backend); err != nil {
+		return nil, err
+	}
+
+	backend, err = unlockBackend(backend, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	store := shadow.NewStore(backend)
+	store.Codec = codec
+	store.Mode = mode
+	store.MaxDeltaChain = cfg.DeltaChain
+	return store, nil
+}
+
+// openStore locks the repository at shadowPath, exclusively if the command
+// modifies it, and migrates it to the current format when needed. Before a
+// modification the metadata is backed up if the last backup is old enough.
+// Callers must Close the returned store.
+func openStore(shadowPath string, cfg config.Config, exclusive bool) (*shadow.Store, error) {
+	store, err := newStore(shadowPath, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Migrating rewrites the repository, so it needs an exclusive lock.
+	if err := store.Lock(exclusive || store.NeedsMigrate(), cfg.LockTimeout); err != nil {
+		return nil, fmt.Errorf("failed to lock repository: %w", err)
+	}
+
+	if _, err := store.Migrate(); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to migrate repository: %w", err)
+	}
+
+	if exclusive {
+		if err := store.AutoBackup(cfg.BackupInterval, cfg.BackupKeep); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to back up metadata: %w", err)
+		}
+	}
+
+	return store, nil
+}
+
+// retentionPolicy returns the retention rules of the config.
+func retentionPolicy(cfg config.Config) (shadow.Retention, error) {
+	maxSize, err := shadow.ParseSize(cfg.Retention.MaxSize)
+	if err != nil {
+		return shadow.Retention{}, fmt.Errorf("failed to parse retention.max_size: %w", err)
+	}
+	return shadow.Retention{
+		KeepLast:    cfg.Retention.KeepLast,
+		KeepDaily:   cfg.Retention.KeepDaily,
+		KeepWeekly:  cfg.Retention.KeepWeekly,
+		KeepMonthly: cfg.Retention.KeepMonthly,
+		MaxAge:      cfg.Retention.MaxAge,
+		MaxSize:     maxSize,
+		Thin:        cfg.Retention.Thin,
+		Protected:   cfg.Retention.Protect,
+	}, nil
+}
+
+// loadFile loads the history of the file at the canonical path absPath and
+// returns it along with the path it is stored under. History recorded under
+// the absolute path, before paths under the home directory were stored
+// relative to it, is adopted. So is, with the inode identity, the history of
+// the file under the path it was moved from, as long as it was saved on this
+// host and is unchanged since. Adopted history moves to the stored path when
+// the list is saved.
+func loadFile(store *shadow.Store, cfg config.Config, absPath string) (*shadow.List, string, error) {
+	key := repo.StoredPath(absPath)
+
+	var aliases []string
+	if key != absPath {
+		aliases = append(aliases, absPath)
+	}
+
+	switch cfg.Identity {
+	case "path":
+	case "inode":
+		if info, err := os.Lstat(absPath); err == nil && info.Mode()&os.ModeSymlink == 0 {
+			meta, err := shadow.ReadMeta(absPath, false)
+			if err != nil {
+				return nil, "", err
+			}
+			index, err := store.LoadIndex()
+			if err != nil {
+				return nil, "", err
+			}
+			// Only files that moved are followed; hard links keep their own
+			// history. Inode numbers are reused once a file is deleted, so
+			// the file must also still hold the newest version.
+			moved := index.FindInode(meta.Inode())
+			if moved != nil && moved.Path != key && moved.Path != absPath {
+				if _, err := os.Lstat(repo.ExpandPath(moved.Path)); os.IsNotExist(err) {
+					hash, err := shadow.HashFile(absPath)
+					if err != nil {
+						return nil, "", err
+					}
+					if hash == moved.Hash {
+						aliases = append(aliases, moved.Path)
+					}
+				}
+			}
+		}
+	default:
+		return nil, "", fmt.Errorf("unknown identity: %s", cfg.Identity)
+	}
+
+	list, err := store.LoadFiles(append([]string{key}, aliases...)...)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, alias := range aliases {
+		list.Rename(alias, key)
+	}
+	return list, key, nil
+}
+
+// loadVersion loads the history of absPath like loadFile and finds the
+// version with the given ID in it. filePath names the file in errors.
+func loadVersion(store *shadow.Store, cfg config.Config, absPath, filePath, versionID string) (*shadow.List, string, *shadow.Version, error) {
+	list, key, err := loadFile(store, cfg, absPath)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to load list: %w", err)
+	}
+	entry := list.FindFile(key)
+	if entry == nil {
+		return nil, "", nil, fmt.Errorf("file not tracked: %s", filePath)
+	}
+	for i := range entry.Versions {
+		if entry.Versions[i].ID == versionID {
+			return list, key, &entry.Versions[i], nil
+		}
+	}
+	return nil, "", nil, fmt.Errorf("version not found: %s", versionID)
+}
@@ -3,18 +3,93 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	RepoPath string `yaml:"repo_path"`
+	RepoPath    string        `yaml:"repo_path"`
+	Compression string        `yaml:"compression"`
+	Storage     string        `yaml:"storage"`
+	DeltaChain  int           `yaml:"delta_chain"`
+	S3Endpoint  string        `yaml:"s3_endpoint"`
+	S3Region    string        `yaml:"s3_region"`
+	KeyFile     string        `yaml:"key_file"`
+	LockTimeout time.Duration `yaml:"lock_timeout"`
+
+	// Xattrs records extended attributes along with mode, owner and mtime.
+	Xattrs bool `yaml:"xattrs"`
+
+	// FollowSymlinks tracks the targets of symlinks instead of the links.
+	FollowSymlinks bool `yaml:"follow_symlinks"`
+
+	// Identity is how a file is recognized: "path", or "inode" to also
+	// follow files that were moved or renamed.
+	Identity string `yaml:"identity"`
+
+	// BackupKeep is how many automatic metadata backups to keep; negative
+	// disables them.
+	BackupKeep     int           `yaml:"backup_keep"`
+	BackupInterval time.Duration `yaml:"backup_interval"`
+
+	Retention Retention `yaml:"retention"`
+
+	// Diff lists drivers changing how shadow diff compares some files; the
+	// first one whose pattern matches is used.
+	Diff []DiffDriver `yaml:"diff"`
+}
+
+// DiffDriver converts files matching Pattern to text before they are
+// compared, or compares them with an external tool. Pattern is a glob
+// matched against the file name, or against the whole path if it contains a
+// slash.
+type DiffDriver struct {
+	Pattern string `yaml:"pattern"`
+
+	// Textconv is a command printing a version as text, with {} standing
+	// for the file holding it, as in "sqlite3 {} .dump".
+	Textconv string `yaml:"textconv"`
+
+	// Command is a diff tool run with {old} and {new} standing for the two
+	// versions, as in "difft {old} {new}".
+	Command string `yaml:"command"`
+}
+
+// Retention holds the rules deciding which versions prune removes.
+type Retention struct {
+	KeepLast    int           `yaml:"keep_last"`
+	KeepDaily   int           `yaml:"keep_daily"`
+	KeepWeekly  int           `yaml:"keep_weekly"`
+	KeepMonthly int           `yaml:"keep_monthly"`
+	MaxAge      time.Duration `yaml:"max_age"`
+	MaxSize     string        `yaml:"max_size"`
+
+	// Thin thins dense history out: everything from the last hour, hourly
+	// versions for a day, daily for a month and weekly after that.
+	Thin bool `yaml:"thin"`
+
+	// Protect lists tags of versions that are never pruned.
+	Protect []string `yaml:"protect"`
+
+	// PruneOnSave prunes the versions of a file after saving it.
+	PruneOnSave bool `yaml:"prune_on_save"`
 }
 
 // DefaultConfig returns default configuration
 func DefaultConfig() Config {
 	return Config{
-		RepoPath: "./",
+		RepoPath:    "./",
+		Compression: "none",
+		Storage:     "full",
+		DeltaChain:  10,
+		LockTimeout: 30 * time.Second,
+		Identity:    "path",
+
+		BackupKeep:     7,
+		BackupInterval: 24 * time.Hour,
+
+		Retention: Retention{Protect: []string{"protected"}},
 	}
 }
 
@@ -44,5 +119,37 @@ func Load() (Config, error) {
 		cfg.RepoPath = "./"
 	}
 
+	if cfg.Compression == "" {
+		cfg.Compression = "none"
+	}
+
+	if cfg.Storage == "" {
+		cfg.Storage = "full"
+	}
+
+	if cfg.DeltaChain <= 0 {
+		cfg.DeltaChain = 10
+	}
+
+	if cfg.LockTimeout <= 0 {
+		cfg.LockTimeout = 30 * time.Second
+	}
+
+	if cfg.Identity == "" {
+		cfg.Identity = "path"
+	}
+
+	if cfg.BackupKeep == 0 {
+		cfg.BackupKeep = 7
+	}
+
+	if cfg.BackupInterval <= 0 {
+		cfg.BackupInterval = 24 * time.Hour
+	}
+
+	if cfg.Retention.Protect == nil {
+		cfg.Retention.Protect = []string{"protected"}
+	}
+
 	return cfg, nil
 }
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -127,3 +128,66 @@ func TestLoad_InvalidYAML(t *testing.T) {
 		t.Error("expected error when YAML is invalid")
 	}
 }
+
+func TestLoad_Retention(t *testing.T) {
+	tmpDir := t.TempDir()
+	originalHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	t.Cleanup(func() {
+		os.Setenv("HOME", originalHome)
+	})
+
+	configDir := filepath.Join(tmpDir, ".config", "sh_adow")
+	os.MkdirAll(configDir, 0755)
+
+	configContent := "retention:\n  keep_last: 5\n  max_age: 720h\n  max_size: 100MB\n  prune_on_save: true\n"
+	os.WriteFile(filepath.Join(configDir, "config.yml"), []byte(configContent), 0644)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	r := cfg.Retention
+	if r.KeepLast != 5 || r.MaxAge != 720*time.Hour || r.MaxSize != "100MB" || !r.PruneOnSave {
+		t.Errorf("unexpected retention: %+v", r)
+	}
+	if len(r.Protect) != 1 || r.Protect[0] != "protected" {
+		t.Errorf("expected default protected tag, got %v", r.Protect)
+	}
+}
+
+func TestLoad_DiffDrivers(t *testing.T) {
+	tmpDir := t.TempDir()
+	originalHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	t.Cleanup(func() {
+		os.Setenv("HOME", originalHome)
+	})
+
+	configDir := filepath.Join(tmpDir, ".config", "sh_adow")
+	os.MkdirAll(configDir, 0755)
+
+	configContent := `diff:
+  - pattern: "*.db"
+    textconv: "sqlite3 {} .dump"
+  - pattern: "*.png"
+    command: "compare {old} {new} png:- | display"
+`
+	os.WriteFile(filepath.Join(configDir, "config.yml"), []byte(configContent), 0644)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if len(cfg.Diff) != 2 {
+		t.Fatalf("expected 2 diff drivers, got %d", len(cfg.Diff))
+	}
+	if d := cfg.Diff[0]; d.Pattern != "*.db" || d.Textconv != "sqlite3 {} .dump" || d.Command != "" {
+		t.Errorf("unexpected driver: %+v", d)
+	}
+	if d := cfg.Diff[1]; d.Pattern != "*.png" || d.Command != "compare {old} {new} png:- | display" {
+		t.Errorf("unexpected driver: %+v", d)
+	}
+}
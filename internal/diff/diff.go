@@ -0,0 +1,387 @@
+// Package diff computes line-based differences between versions of a file.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Op is the kind of an edit.
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Edit is a line that is kept, deleted from the old text or inserted into
+// the new one. Lines keep their line terminator; the last line of a text
+// that does not end in a newline has none.
+type Edit struct {
+	Op   Op
+	Line string
+}
+
+// binarySniff is how much of a text IsBinary looks at, as in git.
+const binarySniff = 8000
+
+// IsBinary reports whether data looks like binary content, that is, whether
+// its beginning contains a NUL byte.
+func IsBinary(data []byte) bool {
+	if len(data) > binarySniff {
+		data = data[:binarySniff]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// SplitLines splits data after each newline.
+func SplitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// Lines returns a shortest edit script turning the lines of a into the lines
+// of b, using Myers' algorithm in linear space.
+func Lines(a, b []string) []Edit {
+	// Lines are compared by number so that every comparison is an int one.
+	ids := make(map[string]int)
+	number := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[l]
+			if !ok {
+				id = len(ids)
+				ids[l] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+
+	x, y := number(a), number(b)
+
+	// Lines found on one side only are never kept, so they are left out of
+	// the search, which is what makes it slow on texts that differ a lot.
+	inA := make([]bool, len(ids))
+	inB := make([]bool, len(ids))
+	for _, id := range x {
+		inA[id] = true
+	}
+	for _, id := range y {
+		inB[id] = true
+	}
+	var keepA, keepB []int
+	d := &differ{}
+	for i, id := range x {
+		if inB[id] {
+			keepA = append(keepA, i)
+			d.a = append(d.a, a[i])
+			d.x = append(d.x, id)
+		}
+	}
+	for i, id := range y {
+		if inA[id] {
+			keepB = append(keepB, i)
+			d.b = append(d.b, b[i])
+			d.y = append(d.y, id)
+		}
+	}
+	d.compare(0, len(d.a), 0, len(d.b))
+
+	// Put the left out lines back.
+	edits := make([]Edit, 0, len(a)+len(b))
+	i, j, ka, kb := 0, 0, 0, 0
+	upTo := func(ea, eb int) {
+		for ; i < ea; i++ {
+			edits = append(edits, Edit{Delete, a[i]})
+		}
+		for ; j < eb; j++ {
+			edits = append(edits, Edit{Insert, b[j]})
+		}
+	}
+	for _, e := range d.edits {
+		switch e.Op {
+		case Equal:
+			upTo(keepA[ka], keepB[kb])
+			edits = append(edits, e)
+			i, j = i+1, j+1
+			ka, kb = ka+1, kb+1
+		case Delete:
+			upTo(keepA[ka]+1, j)
+			ka++
+		case Insert:
+			upTo(i, keepB[kb]+1)
+			kb++
+		}
+	}
+	upTo(len(a), len(b))
+	return reorder(edits)
+}
+
+// Words returns the edits turning text a into text b word by word. Words are
+// runs of letters, digits and underscores, runs of blanks, and any other
+// character on its own, newlines included. Consecutive edits of the same kind
+// are joined.
+func Words(a, b string) []Edit {
+	var out []Edit
+	for _, e := range Lines(splitWords(a), splitWords(b)) {
+		if n := len(out); n > 0 && out[n-1].Op == e.Op {
+			out[n-1].Line += e.Line
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func splitWords(s string) []string {
+	class := func(r rune) int {
+		switch {
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			return 1
+		case r == ' ' || r == '\t':
+			return 2
+		}
+		return 0
+	}
+
+	var words []string
+	start := 0
+	for i, r := range s {
+		if i > start {
+			prev, _ := utf8.DecodeLastRuneInString(s[:i])
+			if c := class(r); c == 0 || c != class(prev) {
+				words = append(words, s[start:i])
+				start = i
+			}
+		}
+	}
+	if start < len(s) {
+		words = append(words, s[start:])
+	}
+	return words
+}
+
+// reorder puts the deletions of each run of changes before its insertions,
+// the way diffs are usually read.
+func reorder(edits []Edit) []Edit {
+	out := make([]Edit, 0, len(edits))
+	for i := 0; i < len(edits); {
+		if edits[i].Op == Equal {
+			out = append(out, edits[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(edits) && edits[j].Op != Equal {
+			j++
+		}
+		for _, op := range []Op{Delete, Insert} {
+			for _, e := range edits[i:j] {
+				if e.Op == op {
+					out = append(out, e)
+				}
+			}
+		}
+		i = j
+	}
+	return out
+}
+
+type differ struct {
+	a, b  []string
+	x, y  []int
+	edits []Edit
+}
+
+func (d *differ) compare(a0, a1, b0, b1 int) {
+	// Common prefix and suffix.
+	var suffix []Edit
+	for a0 < a1 && b0 < b1 && d.x[a0] == d.y[b0] {
+		d.edits = append(d.edits, Edit{Equal, d.a[a0]})
+		a0++
+		b0++
+	}
+	for a0 < a1 && b0 < b1 && d.x[a1-1] == d.y[b1-1] {
+		a1--
+		b1--
+		suffix = append(suffix, Edit{Equal, d.a[a1]})
+	}
+
+	switch {
+	case a0 == a1:
+		for ; b0 < b1; b0++ {
+			d.edits = append(d.edits, Edit{Insert, d.b[b0]})
+		}
+	case b0 == b1:
+		for ; a0 < a1; a0++ {
+			d.edits = append(d.edits, Edit{Delete, d.a[a0]})
+		}
+	default:
+		x, y, u, v := d.middleSnake(a0, a1, b0, b1)
+		d.compare(a0, x, b0, y)
+		for ; x < u; x, y = x+1, y+1 {
+			d.edits = append(d.edits, Edit{Equal, d.a[x]})
+		}
+		d.compare(u, a1, v, b1)
+	}
+
+	for i := len(suffix) - 1; i >= 0; i-- {
+		d.edits = append(d.edits, suffix[i])
+	}
+}
+
+// middleSnake finds the middle snake of a shortest edit script between
+// a[a0:a1] and b[b0:b1], searching from both ends at once. It returns the
+// start and end of the snake.
+func (d *differ) middleSnake(a0, a1, b0, b1 int) (x0, y0, x1, y1 int) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta&1 != 0
+	limit := (n + m + 1) / 2
+
+	off := limit + 1
+	fwd := make([]int, 2*limit+3)
+	bwd := make([]int, 2*limit+3)
+
+	for D := 0; D <= limit; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && fwd[off+k-1] < fwd[off+k+1]) {
+				x = fwd[off+k+1]
+			} else {
+				x = fwd[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.x[a0+x] == d.y[b0+y] {
+				x++
+				y++
+			}
+			fwd[off+k] = x
+			if odd && k >= delta-(D-1) && k <= delta+(D-1) && x+bwd[off+delta-k] >= n {
+				return a0 + sx, b0 + sy, a0 + x, b0 + y
+			}
+		}
+
+		// Backward, x and y count lines from the end.
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && bwd[off+k-1] < bwd[off+k+1]) {
+				x = bwd[off+k+1]
+			} else {
+				x = bwd[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.x[a1-1-x] == d.y[b1-1-y] {
+				x++
+				y++
+			}
+			bwd[off+k] = x
+			if !odd && delta-k >= -D && delta-k <= D && x+fwd[off+delta-k] >= n {
+				return a1 - x, b1 - y, a1 - sx, b1 - sy
+			}
+		}
+	}
+	panic("diff: no middle snake")
+}
+
+// Hunk is a run of edits with surrounding context. Starts are 1-based line
+// numbers.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Edits              []Edit
+}
+
+// Header returns the hunk header of a unified diff.
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+}
+
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprint(start)
+	}
+	if lines == 0 {
+		// An empty range names the line before it.
+		start--
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// Hunks groups the changes in edits into hunks with up to context unchanged
+// lines around them. Hunks whose context would overlap are merged.
+func Hunks(edits []Edit, context int) []Hunk {
+	if context < 0 {
+		context = 0
+	}
+
+	// Line numbers before each edit.
+	oldLine := make([]int, len(edits)+1)
+	newLine := make([]int, len(edits)+1)
+	for i, e := range edits {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if e.Op != Insert {
+			oldLine[i+1]++
+		}
+		if e.Op != Delete {
+			newLine[i+1]++
+		}
+	}
+
+	var hunks []Hunk
+	start, end := -1, -1
+	flush := func() {
+		if start < 0 {
+			return
+		}
+		hunks = append(hunks, Hunk{
+			OldStart: oldLine[start] + 1,
+			OldLines: oldLine[end] - oldLine[start],
+			NewStart: newLine[start] + 1,
+			NewLines: newLine[end] - newLine[start],
+			Edits:    edits[start:end],
+		})
+	}
+	for i, e := range edits {
+		if e.Op == Equal {
+			continue
+		}
+		lo, hi := max(i-context, 0), min(i+context+1, len(edits))
+		if start >= 0 && lo <= end {
+			end = max(end, hi)
+			continue
+		}
+		flush()
+		start, end = lo, hi
+	}
+	flush()
+	return hunks
+}
+
+// Stat counts the inserted and deleted lines of edits.
+func Stat(edits []Edit) (inserted, deleted int) {
+	for _, e := range edits {
+		switch e.Op {
+		case Insert:
+			inserted++
+		case Delete:
+			deleted++
+		}
+	}
+	return inserted, deleted
+}
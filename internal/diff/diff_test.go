@@ -0,0 +1,189 @@
+package diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// apply rebuilds both sides of an edit script.
+func apply(edits []Edit) (a, b []string) {
+	for _, e := range edits {
+		if e.Op != Insert {
+			a = append(a, e.Line)
+		}
+		if e.Op != Delete {
+			b = append(b, e.Line)
+		}
+	}
+	return a, b
+}
+
+func lines(s string) []string {
+	return SplitLines([]byte(s))
+}
+
+func TestSplitLines(t *testing.T) {
+	got := lines("a\nb\nc")
+	want := []string{"a\n", "b\n", "c"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if len(lines("")) != 0 {
+		t.Error("expected no lines for empty data")
+	}
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"a\nb\nc\n", "a\nb\nc\n", 0},
+		{"", "a\nb\n", 2},
+		{"a\nb\n", "", 2},
+		{"a\nb\nc\n", "a\nx\nc\n", 2},
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 5},
+		{"a\nb", "a\nb\n", 2},
+	}
+
+	for _, tt := range tests {
+		edits := Lines(lines(tt.a), lines(tt.b))
+		a, b := apply(edits)
+		if strings.Join(a, "") != tt.a || strings.Join(b, "") != tt.b {
+			t.Errorf("%q -> %q: script does not rebuild inputs", tt.a, tt.b)
+		}
+		ins, del := Stat(edits)
+		if ins+del != tt.edits {
+			t.Errorf("%q -> %q: expected %d edits, got %d", tt.a, tt.b, tt.edits, ins+del)
+		}
+	}
+}
+
+func TestLines_Random(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	gen := func() []string {
+		out := make([]string, rng.Intn(40))
+		for i := range out {
+			out[i] = string(rune('a' + rng.Intn(8)))
+		}
+		return out
+	}
+
+	for i := 0; i < 500; i++ {
+		x, y := gen(), gen()
+		edits := Lines(x, y)
+		a, b := apply(edits)
+		if strings.Join(a, "") != strings.Join(x, "") || strings.Join(b, "") != strings.Join(y, "") {
+			t.Fatalf("%q -> %q: script does not rebuild inputs", x, y)
+		}
+		ins, del := Stat(edits)
+		if want := len(x) + len(y) - 2*lcs(x, y); ins+del != want {
+			t.Fatalf("%q -> %q: expected %d edits, got %d", x, y, want, ins+del)
+		}
+	}
+}
+
+// lcs is the length of the longest common subsequence, by dynamic programming.
+func lcs(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else {
+				dp[i][j] = max(dp[i+1][j], dp[i][j+1])
+			}
+		}
+	}
+	return dp[0][0]
+}
+
+func TestLines_DeletionsFirst(t *testing.T) {
+	edits := Lines(lines("a\nb\nc\n"), lines("a\nx\ny\nc\n"))
+	var ops []Op
+	for _, e := range edits {
+		ops = append(ops, e.Op)
+	}
+	want := []Op{Equal, Delete, Insert, Insert, Equal}
+	if len(ops) != len(want) {
+		t.Fatalf("got %v, want %v", ops, want)
+	}
+	for i := range want {
+		if ops[i] != want[i] {
+			t.Fatalf("got %v, want %v", ops, want)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	got := splitWords("max_size: 10MB,\tkeep  it")
+	want := []string{"max_size", ":", " ", "10MB", ",", "\t", "keep", "  ", "it"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	edits := Words("keep_last: 10 # versions\n", "keep_last: 20 # versions\n")
+	want = []string{"keep_last: ", "10", "20", " # versions\n"}
+	if len(edits) != len(want) {
+		t.Fatalf("unexpected edits: %+v", edits)
+	}
+	for i, e := range edits {
+		if e.Line != want[i] {
+			t.Errorf("edit %d: got %q, want %q", i, e.Line, want[i])
+		}
+	}
+	if edits[1].Op != Delete || edits[2].Op != Insert {
+		t.Errorf("unexpected edits: %+v", edits)
+	}
+}
+
+func TestHunks(t *testing.T) {
+	var a []string
+	for i := 1; i <= 20; i++ {
+		a = append(a, string(rune('a'+i))+"\n")
+	}
+	b := append([]string{}, a...)
+	b[2] = "changed\n"  // line 3
+	b[4] = "changed\n"  // line 5, merged with the change above
+	b[15] = "changed\n" // line 16
+
+	hunks := Hunks(Lines(a, b), 3)
+	if len(hunks) != 2 {
+		t.Fatalf("expected 2 hunks, got %d", len(hunks))
+	}
+	if got := hunks[0].Header(); got != "@@ -1,8 +1,8 @@" {
+		t.Errorf("unexpected header: %s", got)
+	}
+	if got := hunks[1].Header(); got != "@@ -13,7 +13,7 @@" {
+		t.Errorf("unexpected header: %s", got)
+	}
+
+	if hunks := Hunks(Lines(a, a), 3); len(hunks) != 0 {
+		t.Errorf("expected no hunks for equal input, got %d", len(hunks))
+	}
+}
+
+func TestHunks_EmptySide(t *testing.T) {
+	hunks := Hunks(Lines(nil, lines("a\nb\n")), 3)
+	if len(hunks) != 1 || hunks[0].Header() != "@@ -0,0 +1,2 @@" {
+		t.Errorf("unexpected hunks: %+v", hunks)
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	if IsBinary([]byte("plain text\n")) {
+		t.Error("text detected as binary")
+	}
+	if !IsBinary([]byte("PK\x03\x04\x00\x00")) {
+		t.Error("binary not detected")
+	}
+	late := append([]byte(strings.Repeat("a", binarySniff)), 0)
+	if IsBinary(late) {
+		t.Error("expected only the beginning to be checked")
+	}
+}
@@ -0,0 +1,128 @@
+package diff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInterrupted is returned when the context of a driver is canceled while
+// its command runs.
+var ErrInterrupted = errors.New("diff interrupted")
+
+// Driver converts files matching Pattern to text before they are compared,
+// or compares them with an external tool, as configured by a diff driver of
+// the config.
+type Driver struct {
+	Pattern  string
+	Textconv string
+	Command  string
+}
+
+// File is one of the two texts being compared. Path is set if the text is
+// the content of a file on disk.
+type File struct {
+	Label string
+	Data  []byte
+	Path  string
+}
+
+// FindDriver returns the first driver whose pattern matches path, or nil if
+// there is none. A pattern is matched against the base name of path, or
+// against the whole path if it contains a slash.
+func FindDriver(drivers []Driver, path string) (*Driver, error) {
+	for i, d := range drivers {
+		name := filepath.Base(path)
+		if strings.Contains(d.Pattern, "/") {
+			name = path
+		}
+		ok, err := filepath.Match(d.Pattern, name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid diff pattern %q: %w", d.Pattern, err)
+		}
+		if !ok {
+			continue
+		}
+		if (d.Textconv == "") == (d.Command == "") {
+			return nil, fmt.Errorf("diff driver for %q needs either textconv or command", d.Pattern)
+		}
+		return &drivers[i], nil
+	}
+	return nil, nil
+}
+
+// Compare runs the diff command of the driver on the two files. Tools exit
+// with 1 when the files differ, which is not an error.
+func (d *Driver) Compare(ctx context.Context, name string, files [2]File, stdin io.Reader, stdout, stderr io.Writer) error {
+	return withPaths(name, files, func(paths [2]string) error {
+		cmd := shellCommand(ctx, d.Command, paths[0], paths[1])
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+		err := cmd.Run()
+		if ctx.Err() != nil {
+			return ErrInterrupted
+		}
+		var exitErr *exec.ExitError
+		if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+			return fmt.Errorf("failed to run diff command: %w", err)
+		}
+		return nil
+	})
+}
+
+// Convert returns the two files converted to text by the textconv command of
+// the driver.
+func (d *Driver) Convert(ctx context.Context, name string, files [2]File, stderr io.Writer) ([2]File, error) {
+	err := withPaths(name, files, func(paths [2]string) error {
+		for i := range files {
+			cmd := shellCommand(ctx, d.Textconv, paths[i])
+			cmd.Stderr = stderr
+			out, err := cmd.Output()
+			if ctx.Err() != nil {
+				return ErrInterrupted
+			}
+			if err != nil {
+				return fmt.Errorf("failed to convert %s: %w", files[i].Label, err)
+			}
+			files[i].Data = out
+		}
+		return nil
+	})
+	return files, err
+}
+
+// withPaths calls fn with the paths of files on disk. Files without one are
+// written to a temporary directory first, named after name since tools may
+// go by the extension, and removed once fn returns.
+func withPaths(name string, files [2]File, fn func([2]string) error) error {
+	dir, err := os.MkdirTemp("", "shadow-diff-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var paths [2]string
+	for i, prefix := range []string{"old", "new"} {
+		paths[i] = files[i].Path
+		if paths[i] != "" {
+			continue
+		}
+		paths[i] = filepath.Join(dir, prefix+"-"+filepath.Base(name))
+		if err := os.WriteFile(paths[i], files[i].Data, 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", files[i].Label, err)
+		}
+	}
+	return fn(paths)
+}
+
+// shellCommand runs command with the shell. The placeholders {} and {old}
+// stand for the first path and {new} for the second; the paths are passed
+// as arguments, so they need no quoting.
+func shellCommand(ctx context.Context, command string, paths ...string) *exec.Cmd {
+	script := strings.NewReplacer("{}", `"$1"`, "{old}", `"$1"`, "{new}", `"$2"`).Replace(command)
+	return exec.CommandContext(ctx, "sh", append([]string{"-c", script, "shadow"}, paths...)...)
+}
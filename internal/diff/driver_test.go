@@ -0,0 +1,159 @@
+package diff
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindDriver(t *testing.T) {
+	drivers := []Driver{
+		{Pattern: "/srv/*/app.db", Command: "difft {old} {new}"},
+		{Pattern: "*.db", Textconv: "sqlite3 {} .dump"},
+		{Pattern: "srv/*.conf", Textconv: "cat {}"},
+	}
+	tests := map[string]string{
+		"/srv/a/app.db":   "/srv/*/app.db",
+		"/srv/a/b/app.db": "*.db",
+		"/home/u/app.db":  "*.db",
+		"/srv/x.conf":     "",
+		"/srv/x.conf.bak": "",
+		"notes.txt":       "",
+	}
+	for path, want := range tests {
+		d, err := FindDriver(drivers, path)
+		if err != nil {
+			t.Fatalf("FindDriver(%q) failed: %v", path, err)
+		}
+		got := ""
+		if d != nil {
+			got = d.Pattern
+		}
+		if got != want {
+			t.Errorf("FindDriver(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestFindDriver_Invalid(t *testing.T) {
+	tests := map[string][]Driver{
+		"neither":  {{Pattern: "*.db"}},
+		"both":     {{Pattern: "*.db", Textconv: "cat {}", Command: "diff {old} {new}"}},
+		"bad glob": {{Pattern: "[", Textconv: "cat {}"}},
+	}
+	for name, drivers := range tests {
+		if _, err := FindDriver(drivers, "/srv/app.db"); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// Drivers are only checked once they match.
+	drivers := []Driver{{Pattern: "*.txt"}, {Pattern: "*.db", Textconv: "cat {}"}}
+	if d, err := FindDriver(drivers, "/srv/app.db"); err != nil || d != &drivers[1] {
+		t.Errorf("expected the second driver, got %v, %v", d, err)
+	}
+}
+
+// tempDir points temporary files at a fresh directory and returns it.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	return dir
+}
+
+func checkEmpty(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Errorf("temporary file left behind: %s", e.Name())
+	}
+}
+
+func TestDriver_CompareSpacedPaths(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "my files")
+	os.MkdirAll(dir, 0755)
+	tmp := tempDir(t)
+	current := filepath.Join(dir, "current app.db")
+	os.WriteFile(current, []byte("new"), 0644)
+
+	d := &Driver{Command: `printf '%s|%s\n' {old} {new}; cat {old} {new}`}
+	files := [2]File{{Label: "old", Data: []byte("old")}, {Label: "new", Data: []byte("new"), Path: current}}
+	var out bytes.Buffer
+	if err := d.Compare(context.Background(), "app data.db", files, nil, &out, os.Stderr); err != nil {
+		t.Fatalf("Compare failed: %v", err)
+	}
+
+	lines := strings.SplitN(out.String(), "\n", 2)
+	paths := strings.Split(lines[0], "|")
+	if len(paths) != 2 || filepath.Base(paths[0]) != "old-app data.db" || paths[1] != current {
+		t.Errorf("unexpected paths: %q", lines[0])
+	}
+	if len(lines) != 2 || lines[1] != "oldnew" {
+		t.Errorf("unexpected content: %q", out.String())
+	}
+	checkEmpty(t, tmp)
+}
+
+func TestDriver_CompareExitCode(t *testing.T) {
+	tmp := tempDir(t)
+	files := [2]File{{Data: []byte("a")}, {Data: []byte("b")}}
+
+	// Diff tools exit with 1 when the files differ.
+	d := &Driver{Command: "cmp -s {old} {new}"}
+	if err := d.Compare(context.Background(), "x", files, nil, os.Stdout, os.Stderr); err != nil {
+		t.Errorf("expected differing files not to be an error, got %v", err)
+	}
+
+	d = &Driver{Command: "exit 2"}
+	if err := d.Compare(context.Background(), "x", files, nil, os.Stdout, os.Stderr); err == nil {
+		t.Error("expected error for a failing command")
+	}
+	checkEmpty(t, tmp)
+}
+
+func TestDriver_Convert(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "my files")
+	os.MkdirAll(dir, 0755)
+	tmp := tempDir(t)
+	current := filepath.Join(dir, "app data.db")
+	os.WriteFile(current, []byte("b\n"), 0644)
+
+	d := &Driver{Textconv: `printf '%s: ' "$(basename {})"; tr a-z A-Z < {}`}
+	files := [2]File{{Label: "old", Data: []byte("a\n")}, {Label: "new", Path: current}}
+	got, err := d.Convert(context.Background(), "app data.db", files, os.Stderr)
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if string(got[0].Data) != "old-app data.db: A\n" || string(got[1].Data) != "app data.db: B\n" {
+		t.Errorf("unexpected conversion: %q, %q", got[0].Data, got[1].Data)
+	}
+	if got[0].Label != "old" || got[1].Path != current {
+		t.Errorf("expected labels and paths to be kept, got %+v", got)
+	}
+	checkEmpty(t, tmp)
+
+	d = &Driver{Textconv: "false"}
+	if _, err := d.Convert(context.Background(), "app.db", files, os.Stderr); err == nil || !strings.Contains(err.Error(), "old") {
+		t.Errorf("expected error naming the version, got %v", err)
+	}
+	checkEmpty(t, tmp)
+}
+
+func TestDriver_Interrupted(t *testing.T) {
+	tmp := tempDir(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	d := &Driver{Command: "sleep 10"}
+	if err := d.Compare(ctx, "x", [2]File{}, nil, os.Stdout, os.Stderr); err != ErrInterrupted {
+		t.Errorf("expected ErrInterrupted, got %v", err)
+	}
+	checkEmpty(t, tmp)
+}
@@ -0,0 +1,272 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Structured formats.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Format returns the structured format of a file by its name, or "" if it
+// has none.
+func Format(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	}
+	return ""
+}
+
+// Kind is the kind of a structural change.
+type Kind int
+
+const (
+	Added Kind = iota
+	Removed
+	Changed
+)
+
+// Change is a value added, removed or changed at a key path, such as
+// server.tls.enabled or servers[0].port.
+type Change struct {
+	Kind Kind
+	Path string
+	Old  any
+	New  any
+}
+
+// Structure parses a and b in format and returns the changes between them
+// key by key, so that reordering keys and reformatting make no difference.
+// Sequences are compared element by element after aligning equal elements.
+func Structure(a, b []byte, format string) ([]Change, error) {
+	x, err := parse(a, format)
+	if err != nil {
+		return nil, err
+	}
+	y, err := parse(b, format)
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty document is as empty as the other one.
+	if x == nil {
+		x = emptyLike(y)
+	}
+	if y == nil {
+		y = emptyLike(x)
+	}
+
+	var changes []Change
+	compareValues(&changes, "", x, y)
+	return changes, nil
+}
+
+func parse(data []byte, format string) (any, error) {
+	switch format {
+	case FormatJSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		// Numbers are kept as written, large ones would lose precision.
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if dec.More() {
+			return nil, errors.New("unexpected data after JSON value")
+		}
+		return v, nil
+
+	case FormatYAML:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		var docs []any
+		for {
+			var v any
+			if err := dec.Decode(&v); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			docs = append(docs, normalize(v))
+		}
+		switch len(docs) {
+		case 0:
+			return nil, nil
+		case 1:
+			return docs[0], nil
+		}
+		// Documents of a stream are compared as a sequence.
+		return docs, nil
+	}
+	return nil, fmt.Errorf("unknown format: %s", format)
+}
+
+// normalize turns mappings with keys other than strings, which YAML allows,
+// into ones with string keys.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = normalize(e)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case []any:
+		for i, e := range v {
+			v[i] = normalize(e)
+		}
+		return v
+	}
+	return v
+}
+
+func emptyLike(v any) any {
+	switch v.(type) {
+	case map[string]any:
+		return map[string]any{}
+	case []any:
+		return []any{}
+	}
+	return nil
+}
+
+func compareValues(changes *[]Change, path string, a, b any) {
+	switch x := a.(type) {
+	case map[string]any:
+		if y, ok := b.(map[string]any); ok {
+			compareMaps(changes, path, x, y)
+			return
+		}
+	case []any:
+		if y, ok := b.([]any); ok {
+			compareSlices(changes, path, x, y)
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, Change{Kind: Changed, Path: path, Old: a, New: b})
+	}
+}
+
+func compareMaps(changes *[]Change, path string, a, b map[string]any) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		x, inA := a[k]
+		y, inB := b[k]
+		p := joinKey(path, k)
+		switch {
+		case !inB:
+			*changes = append(*changes, Change{Kind: Removed, Path: p, Old: x})
+		case !inA:
+			*changes = append(*changes, Change{Kind: Added, Path: p, New: y})
+		default:
+			compareValues(changes, p, x, y)
+		}
+	}
+}
+
+func compareSlices(changes *[]Change, path string, a, b []any) {
+	key := func(values []any) []string {
+		out := make([]string, len(values))
+		for i, v := range values {
+			out[i] = fmt.Sprintf("%#v", v)
+		}
+		return out
+	}
+
+	// Within a run of changes, elements are paired up in order, the rest
+	// are added or removed.
+	i, j := 0, 0
+	var deleted, inserted []int
+	flush := func() {
+		for n := 0; n < max(len(deleted), len(inserted)); n++ {
+			switch {
+			case n >= len(inserted):
+				*changes = append(*changes, Change{Kind: Removed, Path: joinIndex(path, deleted[n]), Old: a[deleted[n]]})
+			case n >= len(deleted):
+				*changes = append(*changes, Change{Kind: Added, Path: joinIndex(path, inserted[n]), New: b[inserted[n]]})
+			default:
+				compareValues(changes, joinIndex(path, inserted[n]), a[deleted[n]], b[inserted[n]])
+			}
+		}
+		deleted, inserted = nil, nil
+	}
+	for _, e := range Lines(key(a), key(b)) {
+		switch e.Op {
+		case Equal:
+			flush()
+			i, j = i+1, j+1
+		case Delete:
+			deleted = append(deleted, i)
+			i++
+		case Insert:
+			inserted = append(inserted, j)
+			j++
+		}
+	}
+	flush()
+}
+
+func joinKey(path, key string) string {
+	plain := key != ""
+	for _, r := range key {
+		if !(r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			plain = false
+			break
+		}
+	}
+	if !plain {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func joinIndex(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// FormatValue renders a value of a change on one line, the way JSON would.
+func FormatValue(v any) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
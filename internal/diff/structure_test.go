@@ -0,0 +1,126 @@
+package diff
+
+import (
+	"fmt"
+	"testing"
+)
+
+func describe(changes []Change) []string {
+	var out []string
+	for _, c := range changes {
+		switch c.Kind {
+		case Added:
+			out = append(out, fmt.Sprintf("+ %s: %s", c.Path, FormatValue(c.New)))
+		case Removed:
+			out = append(out, fmt.Sprintf("- %s: %s", c.Path, FormatValue(c.Old)))
+		case Changed:
+			out = append(out, fmt.Sprintf("~ %s: %s -> %s", c.Path, FormatValue(c.Old), FormatValue(c.New)))
+		}
+	}
+	return out
+}
+
+func checkChanges(t *testing.T, changes []Change, want []string) {
+	t.Helper()
+	got := describe(changes)
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("change %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := map[string]string{
+		"config.yaml":  FormatYAML,
+		"compose.YML":  FormatYAML,
+		"package.json": FormatJSON,
+		"notes.txt":    "",
+		"Makefile":     "",
+	}
+	for name, want := range tests {
+		if got := Format(name); got != want {
+			t.Errorf("Format(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestStructure_YAML(t *testing.T) {
+	a := `
+server:
+  host: example.com
+  tls:
+    enabled: false
+  ports: [80, 443]
+debug: true
+`
+	// Reordered and reformatted, with a few real changes.
+	b := `
+debug: true
+server:
+  ports:
+    - 8080
+    - 80
+    - 443
+  tls: {enabled: true}
+  "log.level": info
+`
+	changes, err := Structure([]byte(a), []byte(b), FormatYAML)
+	if err != nil {
+		t.Fatalf("Structure failed: %v", err)
+	}
+	checkChanges(t, changes, []string{
+		`- server.host: "example.com"`,
+		`+ server["log.level"]: "info"`,
+		`+ server.ports[0]: 8080`,
+		`~ server.tls.enabled: false -> true`,
+	})
+}
+
+func TestStructure_JSON(t *testing.T) {
+	a := `{"name": "shadow", "tags": ["a", "b"], "limits": {"size": 10}}`
+	b := `{
+	"limits": {"size": 12, "count": null},
+	"name": "shadow",
+	"tags": ["a", "c"]
+}`
+	changes, err := Structure([]byte(a), []byte(b), FormatJSON)
+	if err != nil {
+		t.Fatalf("Structure failed: %v", err)
+	}
+	checkChanges(t, changes, []string{
+		`+ limits.count: null`,
+		`~ limits.size: 10 -> 12`,
+		`~ tags[1]: "b" -> "c"`,
+	})
+}
+
+func TestStructure_Unchanged(t *testing.T) {
+	changes, err := Structure([]byte("{\"a\": 1, \"b\": [1, 2]}"), []byte("{\"b\":[1,2],\"a\":1}\n"), FormatJSON)
+	if err != nil {
+		t.Fatalf("Structure failed: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, got %q", describe(changes))
+	}
+}
+
+func TestStructure_Empty(t *testing.T) {
+	changes, err := Structure(nil, []byte("a: 1\n"), FormatYAML)
+	if err != nil {
+		t.Fatalf("Structure failed: %v", err)
+	}
+	checkChanges(t, changes, []string{`+ a: 1`})
+}
+
+func TestStructure_Invalid(t *testing.T) {
+	if _, err := Structure([]byte("{"), []byte("{}"), FormatJSON); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+	if _, err := Structure([]byte("a: [1"), []byte("a: 1"), FormatYAML); err == nil {
+		t.Error("expected error for invalid YAML")
+	}
+}
@@ -6,9 +6,14 @@ import (
 	"strings"
 
 	"github.com/chhlga/sh_adow/internal/config"
+	"github.com/chhlga/sh_adow/internal/shadow"
 )
 
 func ResolveShadowPath(filePath string, cfg config.Config) (string, error) {
+	if IsRemote(cfg.RepoPath) {
+		return strings.TrimSuffix(cfg.RepoPath, "/") + "/.shadow", nil
+	}
+
 	absFilePath, err := filepath.Abs(filePath)
 	if err != nil {
 		return "", err
@@ -41,7 +46,116 @@ func ResolveShadowPath(filePath string, cfg config.Config) (string, error) {
 	return filepath.Join(shadowBase, ".shadow"), nil
 }
 
+// CanonicalPath returns the path a file's history is kept under: absolute,
+// with symlinks in its directory resolved so that the same file reached
+// through different paths shares one history. A symlink itself is tracked as
+// a link unless follow is set, in which case the path of its target is
+// returned. Paths that do not exist are only made absolute.
+func CanonicalPath(path string, follow bool) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	if follow {
+		if resolved, err := filepath.EvalSymlinks(absPath); err == nil {
+			return resolved, nil
+		}
+	}
+
+	dir, err := filepath.EvalSymlinks(filepath.Dir(absPath))
+	if err != nil {
+		return absPath, nil
+	}
+	return filepath.Join(dir, filepath.Base(absPath)), nil
+}
+
+// StoredPath returns the path the history of the file at the canonical path
+// absPath is stored under. Paths under the home directory are stored relative
+// to it, as "~/...", so that a repository shared between machines with
+// different home directories finds each file under one path.
+func StoredPath(absPath string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return absPath
+	}
+
+	homes := []string{home}
+	if resolved, err := filepath.EvalSymlinks(home); err == nil && resolved != home {
+		homes = append(homes, resolved)
+	}
+	for _, h := range homes {
+		if absPath == h {
+			return "~"
+		}
+		if rel, err := filepath.Rel(h, absPath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.Join("~", rel)
+		}
+	}
+	return absPath
+}
+
+// ExpandPath returns the absolute path of a path returned by StoredPath.
+func ExpandPath(storedPath string) string {
+	if storedPath != "~" && !strings.HasPrefix(storedPath, "~"+string(filepath.Separator)) {
+		return storedPath
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return storedPath
+	}
+	return filepath.Join(home, storedPath[1:])
+}
+
 func EnsureShadowDir(shadowPath string) error {
 	snapshotsDir := filepath.Join(shadowPath, "snapshots")
 	return os.MkdirAll(snapshotsDir, 0755)
 }
+
+// IsRemote reports whether a repo path or shadow path names a remote
+// repository rather than a local directory.
+func IsRemote(path string) bool {
+	return strings.HasPrefix(path, "s3://")
+}
+
+// OpenBackend returns the storage backend for the repository at shadowPath.
+// S3 credentials are taken from the standard AWS environment variables.
+func OpenBackend(shadowPath string, cfg config.Config) (shadow.Backend, error) {
+	if !IsRemote(shadowPath) {
+		return shadow.NewLocalBackend(shadowPath), nil
+	}
+
+	bucket, prefix, err := shadow.ParseS3URL(shadowPath)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := cfg.S3Endpoint
+	if endpoint == "" {
+		endpoint = firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL")
+	}
+
+	region := cfg.S3Region
+	if region == "" {
+		region = firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	}
+
+	return shadow.NewS3Backend(shadow.S3Options{
+		Endpoint:     endpoint,
+		Region:       region,
+		Bucket:       bucket,
+		Prefix:       prefix,
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}), nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/chhlga/sh_adow/internal/config"
+	"github.com/chhlga/sh_adow/internal/shadow"
 )
 
 func TestResolveShadowPath_LocalRepo(t *testing.T) {
@@ -182,3 +183,104 @@ func TestEnsureShadowDir_NestedPath(t *testing.T) {
 		t.Error("nested snapshots directory not created")
 	}
 }
+
+func TestOpenBackend_Local(t *testing.T) {
+	tmpDir := t.TempDir()
+	shadowPath := filepath.Join(tmpDir, ".shadow")
+
+	backend, err := OpenBackend(shadowPath, config.DefaultConfig())
+	if err != nil {
+		t.Fatalf("OpenBackend failed: %v", err)
+	}
+
+	local, ok := backend.(*shadow.LocalBackend)
+	if !ok {
+		t.Fatalf("expected local backend, got %T", backend)
+	}
+	if local.Root() != shadowPath {
+		t.Errorf("expected root %s, got %s", shadowPath, local.Root())
+	}
+}
+
+func TestCanonicalPath(t *testing.T) {
+	tmpDir, _ := filepath.EvalSymlinks(t.TempDir())
+	realDir := filepath.Join(tmpDir, "real")
+	os.MkdirAll(realDir, 0755)
+	os.WriteFile(filepath.Join(realDir, "config.yml"), []byte("test"), 0644)
+	os.Symlink(realDir, filepath.Join(tmpDir, "alias"))
+	os.Symlink("config.yml", filepath.Join(realDir, "link.yml"))
+
+	tests := []struct {
+		path   string
+		follow bool
+		want   string
+	}{
+		{filepath.Join(tmpDir, "alias", "config.yml"), false, filepath.Join(realDir, "config.yml")},
+		{filepath.Join(tmpDir, "alias", "link.yml"), false, filepath.Join(realDir, "link.yml")},
+		{filepath.Join(tmpDir, "alias", "link.yml"), true, filepath.Join(realDir, "config.yml")},
+		{filepath.Join(tmpDir, "missing", "file"), false, filepath.Join(tmpDir, "missing", "file")},
+	}
+	for _, tt := range tests {
+		got, err := CanonicalPath(tt.path, tt.follow)
+		if err != nil {
+			t.Fatalf("CanonicalPath failed: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("CanonicalPath(%s, %v): expected %s, got %s", tt.path, tt.follow, tt.want, got)
+		}
+	}
+}
+
+func TestStoredPath(t *testing.T) {
+	home := t.TempDir()
+	originalHome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	t.Cleanup(func() {
+		os.Setenv("HOME", originalHome)
+	})
+
+	tests := map[string]string{
+		filepath.Join(home, "proj", "config.yml"): filepath.Join("~", "proj", "config.yml"),
+		home:                       "~",
+		home + "-other/config.yml": home + "-other/config.yml",
+		"/etc/hosts":               "/etc/hosts",
+	}
+	for abs, want := range tests {
+		got := StoredPath(abs)
+		if got != want {
+			t.Errorf("StoredPath(%s): expected %s, got %s", abs, want, got)
+		}
+		if back := ExpandPath(got); back != abs {
+			t.Errorf("ExpandPath(%s): expected %s, got %s", got, abs, back)
+		}
+	}
+}
+
+func TestResolveShadowPath_S3(t *testing.T) {
+	cfg := config.Config{RepoPath: "s3://team-bucket/shadows/"}
+
+	shadowPath, err := ResolveShadowPath("/home/user/config.yml", cfg)
+	if err != nil {
+		t.Fatalf("ResolveShadowPath failed: %v", err)
+	}
+
+	expected := "s3://team-bucket/shadows/.shadow"
+	if shadowPath != expected {
+		t.Errorf("expected %s, got %s", expected, shadowPath)
+	}
+}
+
+func TestOpenBackend_S3(t *testing.T) {
+	backend, err := OpenBackend("s3://team-bucket/shadows/.shadow", config.Config{S3Endpoint: "http://localhost:9000"})
+	if err != nil {
+		t.Fatalf("OpenBackend failed: %v", err)
+	}
+
+	if _, ok := backend.(*shadow.S3Backend); !ok {
+		t.Fatalf("expected S3 backend, got %T", backend)
+	}
+
+	if _, err := OpenBackend("s3:///missing-bucket", config.Config{}); err == nil {
+		t.Error("expected error for S3 URL without bucket")
+	}
+}
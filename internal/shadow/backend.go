@@ -0,0 +1,59 @@
+package shadow
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrConflict is returned by StoreMeta when a backend detects that the
+// document was modified by someone else since it was loaded.
+var ErrConflict = errors.New("metadata was modified concurrently, please retry")
+
+// Backend is where a repository keeps its blobs and metadata documents.
+// Blob keys are opaque strings chosen by the Store; metadata documents are
+// small named files such as index.json, whose names may contain slashes.
+// Missing blobs and documents are reported with errors matching
+// os.ErrNotExist.
+type Backend interface {
+	// Put stores the content of r under key, replacing any existing blob.
+	// Readers never observe a partially written blob.
+	Put(key string, r io.Reader) error
+
+	// Get returns the content of the blob stored under key.
+	Get(key string) (io.ReadCloser, error)
+
+	// Stat returns the size of the blob stored under key.
+	Stat(key string) (int64, error)
+
+	// Delete removes the blob stored under key.
+	Delete(key string) error
+
+	// List returns the keys of all stored blobs.
+	List() ([]string, error)
+
+	// LoadMeta returns the metadata document with the given name.
+	LoadMeta(name string) ([]byte, error)
+
+	// StoreMeta atomically replaces the metadata document with the given name.
+	// Backends that can detect concurrent modification return ErrConflict
+	// when the document changed since it was last loaded.
+	StoreMeta(name string, data []byte) error
+
+	// DeleteMeta removes the metadata document with the given name.
+	DeleteMeta(name string) error
+
+	// ListMeta returns the names of all metadata documents starting with
+	// prefix.
+	ListMeta(prefix string) ([]string, error)
+}
+
+// Stager is implemented by backends that can store a blob before its key is
+// known, which lets Put hash content while storing it. Backends without it
+// get the content spooled to a local temporary file and stored from there.
+// Commit may copy the staged content again where it cannot be renamed.
+type Stager interface {
+	// Stage stores the content of r as a pending blob and returns functions
+	// moving it under key and discarding it. A blob that failed to stage
+	// leaves nothing behind.
+	Stage(r io.Reader) (commit func(key string) error, abort func() error, err error)
+}
@@ -0,0 +1,128 @@
+package shadow
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testBackend(t *testing.T, backend Backend) {
+	t.Helper()
+
+	if _, err := backend.Get("abcdef"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not-exist error for missing blob, got %v", err)
+	}
+	if _, err := backend.LoadMeta("list.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not-exist error for missing metadata, got %v", err)
+	}
+
+	if err := backend.Put("abcdef", strings.NewReader("blob content")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := backend.Put("abcdef.zst", strings.NewReader("other")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	r, err := backend.Get("abcdef")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	content, _ := io.ReadAll(r)
+	r.Close()
+	if string(content) != "blob content" {
+		t.Errorf("expected 'blob content', got %q", string(content))
+	}
+
+	size, err := backend.Stat("abcdef")
+	if err != nil || size != 12 {
+		t.Errorf("expected size 12, got %d (%v)", size, err)
+	}
+
+	keys, err := backend.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("expected 2 keys, got %v", keys)
+	}
+
+	if err := backend.Delete("abcdef"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := backend.Stat("abcdef"); !errors.Is(err, os.ErrNotExist) {
+		t.Error("blob should be gone after Delete")
+	}
+	if err := backend.Delete("abcdef"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not-exist error deleting missing blob, got %v", err)
+	}
+
+	if err := backend.StoreMeta("list.json", []byte(`{"files":[]}`)); err != nil {
+		t.Fatalf("StoreMeta failed: %v", err)
+	}
+	data, err := backend.LoadMeta("list.json")
+	if err != nil || string(data) != `{"files":[]}` {
+		t.Errorf("unexpected metadata %q (%v)", string(data), err)
+	}
+
+	if err := backend.StoreMeta("manifests/ab/cdef.json", []byte(`{}`)); err != nil {
+		t.Fatalf("StoreMeta of nested name failed: %v", err)
+	}
+	names, err := backend.ListMeta("manifests/")
+	if err != nil || len(names) != 1 || names[0] != "manifests/ab/cdef.json" {
+		t.Errorf("unexpected metadata listing %v (%v)", names, err)
+	}
+	if err := backend.DeleteMeta("manifests/ab/cdef.json"); err != nil {
+		t.Fatalf("DeleteMeta failed: %v", err)
+	}
+	if _, err := backend.LoadMeta("manifests/ab/cdef.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Error("metadata should be gone after DeleteMeta")
+	}
+	if err := backend.DeleteMeta("manifests/ab/cdef.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not-exist error deleting missing metadata, got %v", err)
+	}
+}
+
+func TestLocalBackend(t *testing.T) {
+	tmpDir := t.TempDir()
+	backend := NewLocalBackend(filepath.Join(tmpDir, ".shadow"))
+	testBackend(t, backend)
+
+	if _, err := os.Stat(filepath.Join(tmpDir, ".shadow", "snapshots", "ab", "cdef.zst")); err != nil {
+		t.Errorf("expected blob fanned out under snapshots/: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, ".shadow", "list.json")); err != nil {
+		t.Errorf("expected list.json in repository root: %v", err)
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testBackend(t, NewMemoryBackend())
+}
+
+func TestStore_ListRoundTrip(t *testing.T) {
+	store := NewStore(NewMemoryBackend())
+
+	list, err := store.LoadList()
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+	if len(list.Files) != 0 {
+		t.Errorf("expected empty list, got %d files", len(list.Files))
+	}
+
+	list.AddVersion("/tmp/a.txt", Version{ID: "v1", Hash: "h1"})
+	if err := store.SaveList(list); err != nil {
+		t.Fatalf("SaveList failed: %v", err)
+	}
+
+	loaded, err := store.LoadList()
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+	if loaded.FindFile("/tmp/a.txt") == nil {
+		t.Error("saved file entry not found after reload")
+	}
+}
@@ -0,0 +1,265 @@
+package shadow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// backupsName lists the metadata backups kept in the repository. Each backup
+// holds copies of the metadata documents under backups/<name>/.
+const backupsName = "backups.json"
+
+// backupAuto is the reason of the backups taken by AutoBackup. Only those are
+// rotated; backups taken before migrations, repairs and restores are kept.
+const backupAuto = "auto"
+
+// Backup is a copy of the repository metadata taken before it was rewritten.
+type Backup struct {
+	Name      string    `json:"name"`
+	Reason    string    `json:"reason"`
+	Created   time.Time `json:"created"`
+	Format    int       `json:"format,omitempty"`
+	Documents []string  `json:"documents"`
+}
+
+type backupCatalog struct {
+	Backups []Backup `json:"backups"`
+}
+
+func (c *backupCatalog) find(name string) int {
+	for i := range c.Backups {
+		if c.Backups[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func loadBackups(backend Backend) (*backupCatalog, error) {
+	data, err := backend.LoadMeta(backupsName)
+	if errors.Is(err, os.ErrNotExist) {
+		return &backupCatalog{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var catalog backupCatalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", backupsName, err)
+	}
+	return &catalog, nil
+}
+
+func storeBackups(backend Backend, catalog *backupCatalog) error {
+	data, err := json.MarshalIndent(catalog, "", "  ")
+	if err != nil {
+		return err
+	}
+	return backend.StoreMeta(backupsName, data)
+}
+
+func backupDocument(backup, name string) string {
+	return "backups/" + backup + "/" + name
+}
+
+// Backups returns the metadata backups of the repository, oldest first.
+func (s *Store) Backups() ([]Backup, error) {
+	catalog, err := loadBackups(s.backend)
+	if err != nil {
+		return nil, err
+	}
+	return catalog.Backups, nil
+}
+
+// copyMeta copies the metadata document src to dst. Documents that cannot be
+// read, such as damaged encrypted ones, are copied as stored.
+func (s *Store) copyMeta(src, dst string) error {
+	backend := s.backend
+	data, err := backend.LoadMeta(src)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		backend = plainBackend(s.backend)
+		data, err = backend.LoadMeta(src)
+	}
+	if err != nil {
+		return err
+	}
+	return backend.StoreMeta(dst, data)
+}
+
+// backupMeta copies every metadata document of the repository into a new
+// backup.
+func (s *Store) backupMeta(reason string) (Backup, error) {
+	catalog, err := loadBackups(s.backend)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	now := time.Now().UTC()
+	backup := Backup{Name: now.Format("20060102T150405Z") + "-" + reason, Reason: reason, Created: now}
+	for n := 2; catalog.find(backup.Name) >= 0; n++ {
+		backup.Name = fmt.Sprintf("%s-%s-%d", now.Format("20060102T150405Z"), reason, n)
+	}
+	if backup.Format, err = readFormat(s.backend); err != nil {
+		return Backup{}, err
+	}
+
+	names, err := liveMetaNames(s.backend)
+	if err != nil {
+		return Backup{}, err
+	}
+	for _, name := range names {
+		err := s.copyMeta(name, backupDocument(backup.Name, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return Backup{}, err
+		}
+		backup.Documents = append(backup.Documents, name)
+	}
+
+	catalog.Backups = append(catalog.Backups, backup)
+	if err := storeBackups(s.backend, catalog); err != nil {
+		return Backup{}, err
+	}
+	return backup, nil
+}
+
+// AutoBackup backs up the metadata unless the newest automatic backup is
+// younger than interval, then removes automatic backups beyond the newest
+// keep. Repositories without metadata are not backed up.
+func (s *Store) AutoBackup(interval time.Duration, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	if _, err := loadIndex(s.backend); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	catalog, err := loadBackups(s.backend)
+	if err != nil {
+		return err
+	}
+	for i := len(catalog.Backups) - 1; i >= 0; i-- {
+		if b := catalog.Backups[i]; b.Reason == backupAuto {
+			if time.Since(b.Created) < interval {
+				return nil
+			}
+			break
+		}
+	}
+
+	if _, err := s.backupMeta(backupAuto); err != nil {
+		return err
+	}
+	return s.rotateBackups(keep)
+}
+
+// rotateBackups removes all but the newest keep automatic backups.
+func (s *Store) rotateBackups(keep int) error {
+	catalog, err := loadBackups(s.backend)
+	if err != nil {
+		return err
+	}
+
+	auto := 0
+	for _, b := range catalog.Backups {
+		if b.Reason == backupAuto {
+			auto++
+		}
+	}
+
+	kept := catalog.Backups[:0]
+	var removed []Backup
+	for _, b := range catalog.Backups {
+		if b.Reason == backupAuto && auto > keep {
+			removed = append(removed, b)
+			auto--
+			continue
+		}
+		kept = append(kept, b)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	// Drop the backups from the catalog first so that it never lists
+	// documents that are gone.
+	catalog.Backups = kept
+	if err := storeBackups(s.backend, catalog); err != nil {
+		return err
+	}
+	for _, b := range removed {
+		for _, name := range b.Documents {
+			if err := s.backend.DeleteMeta(backupDocument(b.Name, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RestoreBackup replaces the metadata of the repository with the backup with
+// the given name, after backing up the current metadata. Versions saved since
+// the backup was taken are dropped; their snapshots can be recovered with
+// Repair. The repository must be locked exclusively.
+func (s *Store) RestoreBackup(name string) error {
+	catalog, err := loadBackups(s.backend)
+	if err != nil {
+		return err
+	}
+
+	i := catalog.find(name)
+	if i < 0 {
+		return fmt.Errorf("backup not found: %s", name)
+	}
+	backup := catalog.Backups[i]
+
+	if _, err := s.backupMeta("pre-restore"); err != nil {
+		return fmt.Errorf("failed to back up metadata: %w", err)
+	}
+
+	current, err := liveMetaNames(s.backend)
+	if err != nil {
+		return err
+	}
+
+	// The index goes last so that it never lists a manifest that has not
+	// been restored yet.
+	restored := make(map[string]bool)
+	for _, doc := range backup.Documents {
+		restored[doc] = true
+		if doc == indexName {
+			continue
+		}
+		if err := s.copyMeta(backupDocument(backup.Name, doc), doc); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", doc, err)
+		}
+	}
+
+	for _, doc := range current {
+		if restored[doc] {
+			continue
+		}
+		if err := s.backend.DeleteMeta(doc); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if restored[indexName] {
+		if err := s.copyMeta(backupDocument(backup.Name, indexName), indexName); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", indexName, err)
+		}
+	}
+
+	if backup.Format == 0 {
+		if err := plainBackend(s.backend).DeleteMeta(formatName); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return writeFormat(s.backend, backup.Format)
+}
@@ -0,0 +1,165 @@
+package shadow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+// Content-defined chunking uses a gear rolling hash: a chunk ends where the
+// low bits of the hash over the trailing bytes are all zero, so an insertion
+// only changes the chunks around it and the rest deduplicate.
+const (
+	chunkMin  = 2 << 10
+	chunkMax  = 64 << 10
+	chunkMask = 1<<13 - 1
+)
+
+// Chunk references one piece of a chunked version.
+type Chunk struct {
+	Hash       string `json:"hash"`
+	Size       int64  `json:"size"`
+	StoredSize int64  `json:"stored_size,omitempty"`
+}
+
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	seed := uint64(0x9e3779b97f4a7c15)
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// cutPoint returns the length of the chunk at the start of data.
+func cutPoint(data []byte) int {
+	if len(data) <= chunkMin {
+		return len(data)
+	}
+
+	limit := min(len(data), chunkMax)
+	var h uint64
+	for i := chunkMin; i < limit; i++ {
+		h = h<<1 + gearTable[data[i]]
+		if h&chunkMask == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
+
+type chunker struct {
+	r          io.Reader
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{r: r, buf: make([]byte, chunkMax)}
+}
+
+// Next returns the next chunk. The slice is only valid until the next call.
+func (c *chunker) Next() ([]byte, error) {
+	if c.end-c.start < chunkMax && !c.eof {
+		copy(c.buf, c.buf[c.start:c.end])
+		c.end -= c.start
+		c.start = 0
+
+		for c.end < len(c.buf) && !c.eof {
+			n, err := c.r.Read(c.buf[c.end:])
+			c.end += n
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := cutPoint(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// putChunked splits the content of r into content-defined chunks and stores
+// each chunk that is not present yet.
+func (s *Store) putChunked(r io.Reader) (Blob, error) {
+	fileHash := sha256.New()
+	c := newChunker(io.TeeReader(r, fileHash))
+
+	blob := Blob{Codec: s.Codec}
+	for {
+		data, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Blob{}, err
+		}
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		stored, err := s.putBytes(hash, data)
+		if err != nil {
+			return Blob{}, err
+		}
+
+		blob.Chunks = append(blob.Chunks, Chunk{Hash: hash, Size: int64(len(data)), StoredSize: stored.StoredSize})
+		blob.Size += int64(len(data))
+		blob.StoredSize += stored.StoredSize
+	}
+
+	blob.Hash = hex.EncodeToString(fileHash.Sum(nil))
+	return blob, nil
+}
+
+// chunkReader streams the concatenated content of a chunked version.
+type chunkReader struct {
+	store  *Store
+	chunks []Chunk
+	cur    io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if len(c.chunks) == 0 {
+				return 0, io.EOF
+			}
+			r, err := c.store.Open(c.chunks[0].Hash)
+			if err != nil {
+				return 0, err
+			}
+			c.cur = r
+			c.chunks = c.chunks[1:]
+		}
+
+		n, err := c.cur.Read(p)
+		if err == io.EOF {
+			c.cur.Close()
+			c.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *chunkReader) Close() error {
+	if c.cur != nil {
+		return c.cur.Close()
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package shadow
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func randomContent(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func splitChunks(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var chunks [][]byte
+	c := newChunker(bytes.NewReader(data))
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+	return chunks
+}
+
+func TestChunker_Bounds(t *testing.T) {
+	data := randomContent(1, 1<<20)
+	chunks := splitChunks(t, data)
+
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("chunks do not reassemble to the original content")
+	}
+
+	for i, chunk := range chunks {
+		if len(chunk) > chunkMax {
+			t.Errorf("chunk %d exceeds max size: %d", i, len(chunk))
+		}
+		if len(chunk) < chunkMin && i != len(chunks)-1 {
+			t.Errorf("chunk %d below min size: %d", i, len(chunk))
+		}
+	}
+}
+
+func TestChunker_InsertionKeepsMostChunks(t *testing.T) {
+	data := randomContent(2, 1<<20)
+	edited := append(append(append([]byte(nil), data[:300000]...), []byte("inserted line\n")...), data[300000:]...)
+
+	original := map[string]bool{}
+	for _, chunk := range splitChunks(t, data) {
+		original[string(chunk)] = true
+	}
+
+	editedChunks := splitChunks(t, edited)
+	shared := 0
+	for _, chunk := range editedChunks {
+		if original[string(chunk)] {
+			shared++
+		}
+	}
+
+	if shared < len(editedChunks)-3 {
+		t.Errorf("expected all but a few chunks to be shared, got %d of %d", shared, len(editedChunks))
+	}
+}
+
+func TestChunker_Empty(t *testing.T) {
+	if chunks := splitChunks(t, nil); len(chunks) != 0 {
+		t.Errorf("expected no chunks for empty input, got %d", len(chunks))
+	}
+}
+
+func TestStorePut_ChunkedDeduplicatesAcrossFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(NewLocalBackend(filepath.Join(tmpDir, ".shadow")))
+	store.Mode = ModeChunked
+
+	data := randomContent(3, 512<<10)
+	hostA := filepath.Join(tmpDir, "host-a.conf")
+	hostB := filepath.Join(tmpDir, "host-b.conf")
+	os.WriteFile(hostA, data, 0644)
+	os.WriteFile(hostB, append(append([]byte(nil), data...), []byte("hostname = b\n")...), 0644)
+
+	blobA, err := store.Put(hostA)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	blobB, err := store.Put(hostB)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	expectedHash, _ := HashFile(hostB)
+	if blobB.Hash != expectedHash {
+		t.Error("chunked blob should record the hash of the whole file")
+	}
+	if blobB.Size != int64(len(data))+13 {
+		t.Errorf("expected logical size %d, got %d", len(data)+13, blobB.Size)
+	}
+
+	list := &List{Files: []FileEntry{}}
+	list.AddVersion(hostA, Version{ID: "a", Hash: blobA.Hash, Size: blobA.Size, Chunks: blobA.Chunks})
+	list.AddVersion(hostB, Version{ID: "b", Hash: blobB.Hash, Size: blobB.Size, Chunks: blobB.Chunks})
+
+	usage := list.Usage()
+	if usage.Logical != blobA.Size+blobB.Size {
+		t.Errorf("expected logical size %d, got %d", blobA.Size+blobB.Size, usage.Logical)
+	}
+	if usage.Stored > blobA.Size+chunkMax {
+		t.Errorf("expected shared chunks to be stored once, got %d unique bytes", usage.Stored)
+	}
+
+	r, err := store.OpenVersion(list.FindFile(hostB), "b")
+	if err != nil {
+		t.Fatalf("OpenVersion failed: %v", err)
+	}
+	defer r.Close()
+	content, _ := io.ReadAll(r)
+	expected, _ := os.ReadFile(hostB)
+	if !bytes.Equal(content, expected) {
+		t.Error("chunked version not reassembled correctly")
+	}
+}
+
+func TestStoreRelease_SharedChunks(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(NewLocalBackend(filepath.Join(tmpDir, ".shadow")))
+	store.Mode = ModeChunked
+
+	path := filepath.Join(tmpDir, "file.bin")
+	os.WriteFile(path, randomContent(4, 256<<10), 0644)
+	blob, _ := store.Put(path)
+
+	list := &List{Files: []FileEntry{}}
+	list.AddVersion("/tmp/a", Version{ID: "v1", Hash: blob.Hash, Chunks: blob.Chunks})
+	list.AddVersion("/tmp/b", Version{ID: "v2", Hash: blob.Hash, Chunks: blob.Chunks})
+
+	garbage, err := store.Unlink(list, "/tmp/a", "v1")
+	if err != nil {
+		t.Fatalf("Unlink failed: %v", err)
+	}
+	if n, _, _ := store.Release(list, garbage...); n != 0 {
+		t.Errorf("chunks still referenced should be kept, %d removed", n)
+	}
+
+	garbage, _ = store.Unlink(list, "/tmp/b", "v2")
+	n, _, _ := store.Release(list, garbage...)
+	if n != len(blob.Chunks) {
+		t.Errorf("expected %d chunks removed, got %d", len(blob.Chunks), n)
+	}
+}
@@ -0,0 +1,109 @@
+package shadow
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs a blob can be stored with. Uncompressed blobs use the empty codec
+// so that snapshots written before compression existed keep working.
+const (
+	CodecNone = ""
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+)
+
+// codecs lists every known codec in the order blobs are looked up.
+var codecs = []string{CodecNone, CodecZstd, CodecGzip}
+
+// ParseCodec normalizes a codec name as found in the config file.
+func ParseCodec(name string) (string, error) {
+	switch name {
+	case "", "none":
+		return CodecNone, nil
+	case "gzip", "gz":
+		return CodecGzip, nil
+	case "zstd", "zst":
+		return CodecZstd, nil
+	}
+	return "", fmt.Errorf("unknown compression codec: %s", name)
+}
+
+func codecExt(codec string) string {
+	switch codec {
+	case CodecGzip:
+		return ".gz"
+	case CodecZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// splitCodec splits the name of a stored blob into its key and codec.
+func splitCodec(name string) (string, string) {
+	for _, codec := range codecs {
+		if ext := codecExt(codec); ext != "" && strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext), codec
+		}
+	}
+	return name, CodecNone
+}
+
+type zstdWriteCloser struct {
+	enc *zstd.Encoder
+}
+
+func (z zstdWriteCloser) Write(p []byte) (int, error) { return z.enc.Write(p) }
+func (z zstdWriteCloser) Close() error                { return z.enc.Close() }
+
+type zstdReadCloser struct {
+	dec *zstd.Decoder
+}
+
+func (z zstdReadCloser) Read(p []byte) (int, error) { return z.dec.Read(p) }
+func (z zstdReadCloser) Close() error               { z.dec.Close(); return nil }
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// compressWriter returns a writer that compresses into w. Closing it flushes
+// the compressor but does not close w.
+func compressWriter(codec string, w io.Writer) (io.WriteCloser, error) {
+	switch codec {
+	case CodecNone:
+		return nopWriteCloser{w}, nil
+	case CodecGzip:
+		return gzip.NewWriter(w), nil
+	case CodecZstd:
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return zstdWriteCloser{enc}, nil
+	}
+	return nil, fmt.Errorf("unknown compression codec: %s", codec)
+}
+
+// decompressReader returns a reader yielding the decompressed content of r.
+func decompressReader(codec string, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case CodecNone:
+		return io.NopCloser(r), nil
+	case CodecGzip:
+		return gzip.NewReader(r)
+	case CodecZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{dec}, nil
+	}
+	return nil, fmt.Errorf("unknown compression codec: %s", codec)
+}
@@ -0,0 +1,62 @@
+package shadow
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestParseCodec(t *testing.T) {
+	tests := map[string]string{
+		"":     CodecNone,
+		"none": CodecNone,
+		"gzip": CodecGzip,
+		"gz":   CodecGzip,
+		"zstd": CodecZstd,
+		"zst":  CodecZstd,
+	}
+
+	for name, expected := range tests {
+		codec, err := ParseCodec(name)
+		if err != nil {
+			t.Errorf("ParseCodec(%q) failed: %v", name, err)
+		}
+		if codec != expected {
+			t.Errorf("ParseCodec(%q): expected %q, got %q", name, expected, codec)
+		}
+	}
+
+	if _, err := ParseCodec("lz4"); err == nil {
+		t.Error("expected error for unknown codec")
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	content := bytes.Repeat([]byte("round trip content "), 500)
+
+	for _, codec := range codecs {
+		var buf bytes.Buffer
+		w, err := compressWriter(codec, &buf)
+		if err != nil {
+			t.Fatalf("compressWriter(%q) failed: %v", codec, err)
+		}
+		w.Write(content)
+		if err := w.Close(); err != nil {
+			t.Fatalf("closing %q writer failed: %v", codec, err)
+		}
+
+		r, err := decompressReader(codec, &buf)
+		if err != nil {
+			t.Fatalf("decompressReader(%q) failed: %v", codec, err)
+		}
+		decoded, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("reading %q stream failed: %v", codec, err)
+		}
+
+		if !bytes.Equal(decoded, content) {
+			t.Errorf("codec %q did not round-trip", codec)
+		}
+	}
+}
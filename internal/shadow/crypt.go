@@ -0,0 +1,651 @@
+package shadow
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Encrypted repositories keep a random data key in key.json, wrapped with a
+// key derived from the user's passphrase or key file. Blobs and metadata are
+// sealed with AES-256-GCM under the data key, so changing the passphrase only
+// rewrites key.json. Blobs and manifests are stored under HMAC-SHA256 names
+// keyed by a subkey of the data key instead of plain hashes, so that nobody
+// can tell from the names whether known content or a path is stored.
+const keyMetaName = "key.json"
+
+// Blobs are sealed in segments so they can be streamed. Each segment nonce is
+// a per-blob random prefix, the segment counter and a final-segment flag, so
+// reordered, truncated or extended blobs fail to open.
+const (
+	sealMagic       = "\xffSHADOW\x01"
+	sealSegment     = 64 << 10
+	sealNoncePrefix = 7
+)
+
+var (
+	// ErrWrongKey is returned when the passphrase or key file does not
+	// unlock the repository.
+	ErrWrongKey = errors.New("wrong passphrase or key file")
+
+	// ErrNotEncrypted is returned when unlocking a repository without a key.
+	ErrNotEncrypted = errors.New("repository is not encrypted")
+
+	// ErrAlreadyEncrypted is returned when enabling encryption twice.
+	ErrAlreadyEncrypted = errors.New("repository is already encrypted")
+)
+
+// kdfIterations is the PBKDF2 work factor for newly wrapped keys.
+var kdfIterations = 600000
+
+type keyFile struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	WrappedKey []byte `json:"wrapped_key"`
+
+	// Pending is set while an existing repository is being converted and
+	// some blobs may still be in plaintext.
+	Pending bool `json:"pending,omitempty"`
+}
+
+// EncryptedBackend seals everything written to an underlying backend. Blobs
+// are stored under names derived with nameHash from their keys; List returns
+// those names.
+type EncryptedBackend struct {
+	inner   Backend
+	aead    cipher.AEAD
+	dataKey []byte
+	names   []byte
+	key     keyFile
+	pending bool
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func deriveKEK(secret []byte, kf keyFile) (cipher.AEAD, error) {
+	if kf.KDF != "pbkdf2-sha256" {
+		return nil, fmt.Errorf("unsupported key derivation: %s", kf.KDF)
+	}
+	kek, err := pbkdf2.Key(sha256.New, string(secret), kf.Salt, kf.Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(kek)
+}
+
+// wrapKey seals dataKey under a key derived from secret with a fresh salt.
+func wrapKey(secret, dataKey []byte) (keyFile, error) {
+	kf := keyFile{KDF: "pbkdf2-sha256", Iterations: kdfIterations, Salt: make([]byte, 16)}
+	if _, err := rand.Read(kf.Salt); err != nil {
+		return keyFile{}, err
+	}
+
+	kek, err := deriveKEK(secret, kf)
+	if err != nil {
+		return keyFile{}, err
+	}
+
+	nonce := make([]byte, kek.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return keyFile{}, err
+	}
+	kf.WrappedKey = kek.Seal(nonce, nonce, dataKey, []byte(keyMetaName))
+	return kf, nil
+}
+
+func unwrapKey(secret []byte, kf keyFile) ([]byte, error) {
+	kek, err := deriveKEK(secret, kf)
+	if err != nil {
+		return nil, err
+	}
+	if len(kf.WrappedKey) < kek.NonceSize() {
+		return nil, ErrWrongKey
+	}
+
+	nonce, sealed := kf.WrappedKey[:kek.NonceSize()], kf.WrappedKey[kek.NonceSize():]
+	dataKey, err := kek.Open(nil, nonce, sealed, []byte(keyMetaName))
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return dataKey, nil
+}
+
+func loadKeyFile(backend Backend) (keyFile, error) {
+	data, err := backend.LoadMeta(keyMetaName)
+	if err != nil {
+		return keyFile{}, err
+	}
+
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return keyFile{}, fmt.Errorf("failed to parse %s: %w", keyMetaName, err)
+	}
+	return kf, nil
+}
+
+func storeKeyFile(backend Backend, kf keyFile) error {
+	data, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return backend.StoreMeta(keyMetaName, data)
+}
+
+// IsEncrypted reports whether the repository in backend has encryption
+// enabled.
+func IsEncrypted(backend Backend) (bool, error) {
+	_, err := backend.LoadMeta(keyMetaName)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Unlock returns a backend that transparently decrypts the repository in
+// backend using secret.
+func Unlock(backend Backend, secret []byte) (*EncryptedBackend, error) {
+	kf, err := loadKeyFile(backend)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotEncrypted
+		}
+		return nil, err
+	}
+
+	dataKey, err := unwrapKey(secret, kf)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	names := hmac.New(sha256.New, dataKey)
+	names.Write([]byte("shadow names"))
+	return &EncryptedBackend{inner: backend, aead: aead, dataKey: dataKey, names: names.Sum(nil), key: kf, pending: kf.Pending}, nil
+}
+
+// nameHash returns the keyed hash naming s in the underlying backend.
+func (b *EncryptedBackend) nameHash(s string) string {
+	mac := hmac.New(sha256.New, b.names)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// blobName returns the name the blob stored as name is kept under. Each hash
+// of its key is replaced by its keyed hash; the codec extension stays, and so
+// does the separator of delta keys, so that deltas remain recognizable.
+func (b *EncryptedBackend) blobName(name string) string {
+	key, codec := splitCodec(name)
+	parts := strings.Split(key, "-")
+	for i, part := range parts {
+		parts[i] = b.nameHash(part)
+	}
+	return strings.Join(parts, "-") + codecExt(codec)
+}
+
+// EnableEncryption encrypts an existing repository in place with a new data
+// key wrapped by secret. If a previous attempt was interrupted it resumes
+// with the same key, so secret must match the one used then.
+func EnableEncryption(backend Backend, secret []byte) (*EncryptedBackend, error) {
+	b, err := Unlock(backend, secret)
+	switch {
+	case err == nil && !b.pending:
+		return nil, ErrAlreadyEncrypted
+	case errors.Is(err, ErrNotEncrypted):
+		dataKey := make([]byte, 32)
+		if _, err := rand.Read(dataKey); err != nil {
+			return nil, err
+		}
+		kf, err := wrapKey(secret, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		kf.Pending = true
+		if err := storeKeyFile(backend, kf); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", keyMetaName, err)
+		}
+		if b, err = Unlock(backend, secret); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	}
+
+	keys, err := backend.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if err := b.seal(key); err != nil {
+			return nil, fmt.Errorf("failed to encrypt %s: %w", key, err)
+		}
+	}
+
+	names, err := metaNames(b)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		data, err := b.LoadMeta(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := b.StoreMeta(name, data); err != nil {
+			return nil, err
+		}
+	}
+	if err := NewStore(b).renameManifests(); err != nil {
+		return nil, fmt.Errorf("failed to rename manifests: %w", err)
+	}
+
+	b.key.Pending = false
+	if err := storeKeyFile(backend, b.key); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", keyMetaName, err)
+	}
+	b.pending = false
+	return b, nil
+}
+
+// seal moves the plaintext blob under key to its name in encrypted form. A
+// blob that is already sealed was moved before.
+func (b *EncryptedBackend) seal(key string) error {
+	raw, err := b.inner.Get(key)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(raw)
+	header, _ := br.Peek(len(sealMagic))
+	if string(header) == sealMagic {
+		raw.Close()
+		return nil
+	}
+
+	err = b.Put(key, br)
+	raw.Close()
+	if err != nil {
+		return err
+	}
+	return b.inner.Delete(key)
+}
+
+// rename moves the blob sealed under its key, as repositories in format 3
+// stored it, to its name. A blob that is gone was moved before.
+func (b *EncryptedBackend) rename(key string) error {
+	raw, err := b.inner.Get(key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r, err := b.open(key, raw)
+	if err != nil {
+		return err
+	}
+	err = b.Put(key, r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	return b.inner.Delete(key)
+}
+
+// Rekey wraps the data key with a new secret. Blobs and metadata are not
+// rewritten.
+func (b *EncryptedBackend) Rekey(secret []byte) error {
+	kf, err := wrapKey(secret, b.dataKey)
+	if err != nil {
+		return err
+	}
+	kf.Pending = b.key.Pending
+
+	if err := storeKeyFile(b.inner, kf); err != nil {
+		return err
+	}
+	b.key = kf
+	return nil
+}
+
+// Lock locks the underlying backend if it supports locking.
+func (b *EncryptedBackend) Lock(exclusive bool, timeout time.Duration) (func() error, error) {
+	if locker, ok := b.inner.(Locker); ok {
+		return locker.Lock(exclusive, timeout)
+	}
+	return func() error { return nil }, nil
+}
+
+func (b *EncryptedBackend) Put(key string, r io.Reader) error {
+	return b.putSealed(b.blobName(key), r)
+}
+
+// putSealed seals r under name in the underlying backend. The name is bound
+// into every segment.
+func (b *EncryptedBackend) putSealed(name string, r io.Reader) error {
+	return b.inner.Put(name, newSealReader(b.aead, name, r))
+}
+
+// Stage seals r into the underlying backend under a temporary staging key,
+// so that the content is never spooled in plaintext. The blob key is bound
+// into every sealed segment and only known once r is read, so commit opens
+// the staged blob and seals it again under key. A staging blob left behind by
+// a crash ends in .tmp, which GC removes.
+func (b *EncryptedBackend) Stage(r io.Reader) (func(string) error, func() error, error) {
+	staging := "staged-" + randomSuffix() + ".tmp"
+	if err := b.putSealed(staging, r); err != nil {
+		b.inner.Delete(staging)
+		return nil, nil, err
+	}
+
+	abort := func() error {
+		if err := b.inner.Delete(staging); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	commit := func(key string) error {
+		raw, err := b.inner.Get(staging)
+		if err != nil {
+			return err
+		}
+		staged, err := b.open(staging, raw)
+		if err != nil {
+			return err
+		}
+		err = b.Put(key, staged)
+		staged.Close()
+		if err != nil {
+			return err
+		}
+		return abort()
+	}
+	return commit, abort, nil
+}
+
+func (b *EncryptedBackend) Get(key string) (io.ReadCloser, error) {
+	name := b.blobName(key)
+	raw, err := b.inner.Get(name)
+	if errors.Is(err, os.ErrNotExist) && b.pending {
+		// Not converted yet.
+		name = key
+		raw, err = b.inner.Get(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.open(name, raw)
+}
+
+// open decrypts raw, the blob stored under name in the underlying backend.
+func (b *EncryptedBackend) open(name string, raw io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(raw, sealSegment+b.aead.Overhead()+len(sealMagic))
+	header, _ := br.Peek(len(sealMagic))
+	if string(header) != sealMagic {
+		if b.pending {
+			return &blobReader{ReadCloser: io.NopCloser(br), raw: raw}, nil
+		}
+		raw.Close()
+		return nil, fmt.Errorf("blob %s is not encrypted", name)
+	}
+	br.Discard(len(sealMagic))
+
+	r, err := newOpenReader(b.aead, name, br)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return &blobReader{ReadCloser: io.NopCloser(r), raw: raw}, nil
+}
+
+// Stat returns the size of the blob before encryption, which follows from the
+// sealed size since every segment but the last is full.
+func (b *EncryptedBackend) Stat(key string) (int64, error) {
+	size, err := b.inner.Stat(b.blobName(key))
+	if errors.Is(err, os.ErrNotExist) && b.pending {
+		return b.inner.Stat(key)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	body := size - int64(len(sealMagic)+sealNoncePrefix)
+	overhead := int64(b.aead.Overhead())
+	if body < overhead {
+		return size, nil
+	}
+	segments := (body + sealSegment + overhead - 1) / (sealSegment + overhead)
+	return body - segments*overhead, nil
+}
+
+func (b *EncryptedBackend) Delete(key string) error {
+	err := b.inner.Delete(b.blobName(key))
+	if b.pending && (err == nil || errors.Is(err, os.ErrNotExist)) {
+		if perr := b.inner.Delete(key); !errors.Is(perr, os.ErrNotExist) {
+			err = perr
+		}
+	}
+	return err
+}
+
+func (b *EncryptedBackend) List() ([]string, error) {
+	return b.inner.List()
+}
+
+func (b *EncryptedBackend) LoadMeta(name string) ([]byte, error) {
+	data, err := b.inner.LoadMeta(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, []byte(sealMagic)) {
+		if b.pending {
+			return data, nil
+		}
+		return nil, fmt.Errorf("%s is not encrypted", name)
+	}
+	data = data[len(sealMagic):]
+
+	n := b.aead.NonceSize()
+	if len(data) < n {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", name, errBadSeal)
+	}
+	plain, err := b.aead.Open(nil, data[:n], data[n:], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", name, errBadSeal)
+	}
+	return plain, nil
+}
+
+func (b *EncryptedBackend) DeleteMeta(name string) error {
+	return b.inner.DeleteMeta(name)
+}
+
+func (b *EncryptedBackend) ListMeta(prefix string) ([]string, error) {
+	return b.inner.ListMeta(prefix)
+}
+
+func (b *EncryptedBackend) StoreMeta(name string, data []byte) error {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	sealed := append([]byte(sealMagic), nonce...)
+	sealed = b.aead.Seal(sealed, nonce, data, []byte(name))
+	return b.inner.StoreMeta(name, sealed)
+}
+
+var errBadSeal = errors.New("authentication failed")
+
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// sealReader encrypts a plaintext stream segment by segment.
+type sealReader struct {
+	aead    cipher.AEAD
+	ad      []byte
+	src     *bufio.Reader
+	prefix  []byte
+	counter uint32
+	plain   []byte
+	out     []byte
+	done    bool
+	err     error
+}
+
+func newSealReader(aead cipher.AEAD, key string, r io.Reader) *sealReader {
+	s := &sealReader{
+		aead:   aead,
+		ad:     []byte(key),
+		src:    bufio.NewReaderSize(r, sealSegment),
+		prefix: make([]byte, sealNoncePrefix),
+		plain:  make([]byte, sealSegment),
+	}
+	if _, err := rand.Read(s.prefix); err != nil {
+		s.err = err
+	}
+	s.out = append([]byte(sealMagic), s.prefix...)
+	return s
+}
+
+func (s *sealReader) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.next()
+	}
+
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+func (s *sealReader) next() {
+	n, err := io.ReadFull(s.src, s.plain)
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		s.err = err
+		return
+	default:
+		if _, err := s.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			s.err = err
+			return
+		}
+	}
+
+	s.out = s.aead.Seal(s.out[:0], segmentNonce(s.prefix, s.counter, last), s.plain[:n], s.ad)
+	s.counter++
+	s.done = last
+}
+
+// openReader decrypts a stream written by sealReader, without the magic.
+type openReader struct {
+	aead    cipher.AEAD
+	ad      []byte
+	src     *bufio.Reader
+	prefix  []byte
+	counter uint32
+	sealed  []byte
+	plain   []byte
+	out     []byte
+	done    bool
+}
+
+func newOpenReader(aead cipher.AEAD, key string, r *bufio.Reader) (*openReader, error) {
+	prefix := make([]byte, sealNoncePrefix)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, errBadSeal
+	}
+	return &openReader{
+		aead:   aead,
+		ad:     []byte(key),
+		src:    r,
+		prefix: prefix,
+		sealed: make([]byte, sealSegment+aead.Overhead()),
+		plain:  make([]byte, 0, sealSegment),
+	}, nil
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.out) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		if err := o.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, o.out)
+	o.out = o.out[n:]
+	return n, nil
+}
+
+func (o *openReader) next() error {
+	n, err := io.ReadFull(o.src, o.sealed)
+	last := false
+	switch {
+	case err == io.EOF:
+		return errBadSeal
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, err := o.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	plain, err := o.aead.Open(o.plain[:0], segmentNonce(o.prefix, o.counter, last), o.sealed[:n], o.ad)
+	if err != nil {
+		return errBadSeal
+	}
+	o.out = plain
+	o.counter++
+	o.done = last
+	return nil
+}
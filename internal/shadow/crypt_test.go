@@ -0,0 +1,364 @@
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func init() {
+	kdfIterations = 1000
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	backend := NewMemoryBackend()
+	enc, err := EnableEncryption(backend, []byte("secret"))
+	if err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+
+	for _, size := range []int{0, 1, sealSegment - 1, sealSegment, sealSegment + 1, 3*sealSegment + 17} {
+		content := bytes.Repeat([]byte{'x'}, size)
+		if err := enc.Put("abcdef", bytes.NewReader(content)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if n, _ := enc.Stat("abcdef"); n != int64(size) {
+			t.Errorf("size %d: Stat returned %d", size, n)
+		}
+
+		r, err := enc.Get("abcdef")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("size %d: read failed: %v", size, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("size %d: content mismatch", size)
+		}
+	}
+}
+
+func TestEncryptedBackend_Conformance(t *testing.T) {
+	enc, err := EnableEncryption(NewMemoryBackend(), []byte("secret"))
+	if err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+	testBackend(t, enc)
+}
+
+func TestEncryptedBackend_NoPlaintext(t *testing.T) {
+	backend := NewMemoryBackend()
+	enc, _ := EnableEncryption(backend, []byte("secret"))
+
+	enc.Put("abcdef", strings.NewReader("api_token: hunter2"))
+	enc.StoreMeta("list.json", []byte(`{"files":[{"path":"/home/user/.netrc"}]}`))
+
+	if _, err := backend.Get("abcdef"); err == nil {
+		t.Error("blob stored under its key")
+	}
+	raw, err := backend.Get(enc.blobName("abcdef"))
+	if err != nil {
+		t.Fatalf("blob not stored under its name: %v", err)
+	}
+	data, _ := io.ReadAll(raw)
+	if bytes.Contains(data, []byte("hunter2")) {
+		t.Error("blob stored in plaintext")
+	}
+
+	meta, _ := backend.LoadMeta("list.json")
+	if bytes.Contains(meta, []byte(".netrc")) {
+		t.Error("metadata stored in plaintext")
+	}
+}
+
+// watchedBackend calls put before every Put.
+type watchedBackend struct {
+	*LocalBackend
+	put func()
+}
+
+func (b *watchedBackend) Put(key string, r io.Reader) error {
+	if b.put != nil {
+		b.put()
+	}
+	return b.LocalBackend.Put(key, r)
+}
+
+func TestEncryptedBackend_StageNoPlaintext(t *testing.T) {
+	tmpDir := t.TempDir()
+	spool := filepath.Join(tmpDir, "tmp")
+	os.MkdirAll(spool, 0755)
+	t.Setenv("TMPDIR", spool)
+
+	root := filepath.Join(tmpDir, ".shadow")
+	inner := &watchedBackend{LocalBackend: NewLocalBackend(root)}
+	enc, err := EnableEncryption(inner, []byte("secret"))
+	if err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+
+	// Nothing on disk holds the content in plaintext while it is staged, or
+	// after it is stored.
+	var plaintextFiles func() []string
+	inner.put = func() {
+		if found := plaintextFiles(); len(found) > 0 {
+			t.Errorf("content in plaintext while storing: %v", found)
+		}
+	}
+	plaintextFiles = func() []string {
+		var found []string
+		for _, dir := range []string{root, spool} {
+			filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+				if err == nil && d.Type().IsRegular() {
+					if data, _ := os.ReadFile(path); bytes.Contains(data, []byte("hunter2")) {
+						found = append(found, path)
+					}
+				}
+				return nil
+			})
+		}
+		return found
+	}
+
+	content := strings.Repeat("api_token: hunter2\n", 10000)
+	commit, _, err := enc.Stage(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Stage failed: %v", err)
+	}
+	if found := plaintextFiles(); len(found) > 0 {
+		t.Errorf("staged content in plaintext: %v", found)
+	}
+	if err := commit("abcdef"); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+
+	srcPath := filepath.Join(tmpDir, "secrets.env")
+	os.WriteFile(srcPath, []byte(content+"more\n"), 0600)
+	blob, err := NewStore(enc).PutContext(context.Background(), srcPath, nil)
+	if err != nil {
+		t.Fatalf("PutContext failed: %v", err)
+	}
+
+	if found := plaintextFiles(); len(found) > 0 {
+		t.Errorf("content stored in plaintext: %v", found)
+	}
+	if entries, _ := os.ReadDir(spool); len(entries) > 0 {
+		t.Errorf("content spooled to the temporary directory: %v", entries)
+	}
+	report, err := NewStore(enc).GC(GCOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	for _, item := range report.Removed {
+		if item.Temp {
+			t.Errorf("staging blob left behind: %s", item.Name)
+		}
+	}
+
+	for key, want := range map[string]string{"abcdef": content, blob.Hash: content + "more\n"} {
+		r, err := enc.Get(key)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		got, _ := io.ReadAll(r)
+		r.Close()
+		if string(got) != want {
+			t.Errorf("%s: content mismatch", key)
+		}
+	}
+}
+
+func TestEncryptedBackend_Tampering(t *testing.T) {
+	backend := NewMemoryBackend()
+	enc, _ := EnableEncryption(backend, []byte("secret"))
+
+	content := bytes.Repeat([]byte("secret data "), 20000)
+	enc.Put("aaaaaa", bytes.NewReader(content))
+	enc.Put("bbbbbb", bytes.NewReader(content))
+
+	name := enc.blobName("aaaaaa")
+	raw, _ := backend.Get(name)
+	sealed, _ := io.ReadAll(raw)
+
+	tests := map[string][]byte{
+		"flipped":   append(append([]byte(nil), sealed[:100]...), append([]byte{sealed[100] ^ 1}, sealed[101:]...)...),
+		"truncated": sealed[:len(sealed)-sealSegment/2],
+		"segment":   sealed[:len(sealMagic)+sealNoncePrefix+sealSegment+enc.aead.Overhead()],
+	}
+	for name, data := range tests {
+		backend.Put(enc.blobName("aaaaaa"), bytes.NewReader(data))
+		r, err := enc.Get("aaaaaa")
+		if err == nil {
+			_, err = io.ReadAll(r)
+			r.Close()
+		}
+		if err == nil {
+			t.Errorf("%s: expected tampered blob to fail", name)
+		}
+	}
+
+	// A sealed blob moved to another key must not open either.
+	backend.Put(enc.blobName("bbbbbb"), bytes.NewReader(sealed))
+	r, err := enc.Get("bbbbbb")
+	if err == nil {
+		_, err = io.ReadAll(r)
+	}
+	if err == nil {
+		t.Error("expected blob under a different key to fail")
+	}
+}
+
+func TestUnlock_WrongKey(t *testing.T) {
+	backend := NewMemoryBackend()
+	if _, err := Unlock(backend, []byte("secret")); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("expected ErrNotEncrypted, got %v", err)
+	}
+
+	EnableEncryption(backend, []byte("secret"))
+	if _, err := Unlock(backend, []byte("wrong")); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("expected ErrWrongKey, got %v", err)
+	}
+	if _, err := EnableEncryption(backend, []byte("secret")); !errors.Is(err, ErrAlreadyEncrypted) {
+		t.Errorf("expected ErrAlreadyEncrypted, got %v", err)
+	}
+}
+
+func TestEnableEncryption_ExistingRepository(t *testing.T) {
+	tmpDir := t.TempDir()
+	backend := NewLocalBackend(filepath.Join(tmpDir, ".shadow"))
+	store := NewStore(backend)
+	store.Codec = CodecZstd
+
+	srcPath := filepath.Join(tmpDir, "file.txt")
+	os.WriteFile(srcPath, []byte("password=hunter2"), 0644)
+	blob, _ := store.Put(srcPath)
+
+	list := &List{}
+	list.AddVersion(srcPath, Version{ID: "v1", Hash: blob.Hash, Notes: "before rotation"})
+	store.SaveList(list)
+
+	if encrypted, _ := IsEncrypted(backend); encrypted {
+		t.Fatal("repository should not be encrypted yet")
+	}
+
+	enc, err := EnableEncryption(backend, []byte("secret"))
+	if err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+	if encrypted, _ := IsEncrypted(backend); !encrypted {
+		t.Fatal("repository should be encrypted")
+	}
+
+	listData, _ := os.ReadFile(filepath.Join(tmpDir, ".shadow", "list.json"))
+	if bytes.Contains(listData, []byte("before rotation")) {
+		t.Error("list.json should be encrypted")
+	}
+
+	if _, err := NewStore(backend).LoadList(); err == nil {
+		t.Error("loading the list without the key should fail")
+	}
+
+	loaded, err := NewStore(enc).LoadList()
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+	if loaded.Files[0].Versions[0].Notes != "before rotation" {
+		t.Error("list content not preserved")
+	}
+
+	r, err := NewStore(enc).OpenVersion(loaded.FindFile(srcPath), "v1")
+	if err != nil {
+		t.Fatalf("OpenVersion failed: %v", err)
+	}
+	content, _ := io.ReadAll(r)
+	r.Close()
+	if string(content) != "password=hunter2" {
+		t.Errorf("expected original content, got %q", string(content))
+	}
+}
+
+func TestRekey(t *testing.T) {
+	backend := NewMemoryBackend()
+	enc, _ := EnableEncryption(backend, []byte("old"))
+	enc.Put("abcdef", strings.NewReader("content"))
+
+	before, _ := backend.Get(enc.blobName("abcdef"))
+	sealed, _ := io.ReadAll(before)
+
+	if err := enc.Rekey([]byte("new")); err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+
+	after, _ := backend.Get(enc.blobName("abcdef"))
+	resealed, _ := io.ReadAll(after)
+	if !bytes.Equal(sealed, resealed) {
+		t.Error("rotating the key should not rewrite blobs")
+	}
+
+	if _, err := Unlock(backend, []byte("old")); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("old secret should no longer unlock, got %v", err)
+	}
+
+	reopened, err := Unlock(backend, []byte("new"))
+	if err != nil {
+		t.Fatalf("Unlock with new secret failed: %v", err)
+	}
+	r, err := reopened.Get("abcdef")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	content, _ := io.ReadAll(r)
+	if string(content) != "content" {
+		t.Errorf("expected 'content', got %q", string(content))
+	}
+}
+
+func TestEncryptedBackend_KeyedNames(t *testing.T) {
+	tmpDir := t.TempDir()
+	inner := NewMemoryBackend()
+	enc, _ := EnableEncryption(inner, []byte("secret"))
+	store := NewStore(enc)
+
+	srcPath := filepath.Join(tmpDir, "secrets.env")
+	os.WriteFile(srcPath, []byte("api_token: hunter2"), 0600)
+	blob, err := store.Put(srcPath)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	list := &List{}
+	list.AddVersion(srcPath, Version{ID: "v1", Hash: blob.Hash, Size: blob.Size})
+	if err := store.SaveList(list); err != nil {
+		t.Fatalf("SaveList failed: %v", err)
+	}
+
+	// Neither the content hash nor the hash of the path can be looked for.
+	names, _ := inner.List()
+	for _, name := range names {
+		if strings.Contains(name, blob.Hash) {
+			t.Errorf("blob named by its content hash: %s", name)
+		}
+	}
+	manifests, _ := inner.ListMeta("manifests/")
+	for _, name := range manifests {
+		if name == manifestName(srcPath) {
+			t.Errorf("manifest named by the hash of its path: %s", name)
+		}
+	}
+
+	report, err := store.Fsck(1)
+	if err != nil || !report.OK() {
+		t.Errorf("expected clean fsck, got %+v (%v)", report, err)
+	}
+	gc, err := store.GC(GCOptions{DryRun: true})
+	if err != nil || len(gc.Removed) != 0 || gc.Reachable != 1 {
+		t.Errorf("expected nothing to collect, got %+v (%v)", gc, err)
+	}
+}
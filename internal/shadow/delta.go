@@ -0,0 +1,149 @@
+package shadow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Binary deltas encode a target as a sequence of copies from a base and
+// literal inserts. Matches are found rsync-style: the base is indexed in
+// fixed-size blocks and a rolling hash is slid over the target.
+const (
+	deltaBlock = 32
+	deltaPrime = 16777619
+
+	deltaOpCopy   = 'C'
+	deltaOpInsert = 'I'
+)
+
+var errBadDelta = fmt.Errorf("%w: malformed delta", ErrCorrupt)
+
+func deltaBlockHash(b []byte) uint64 {
+	var h uint64
+	for _, c := range b {
+		h = h*deltaPrime + uint64(c)
+	}
+	return h
+}
+
+func encodeDelta(base, target []byte) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(target)))
+
+	index := make(map[uint64]int)
+	for i := 0; i+deltaBlock <= len(base); i += deltaBlock {
+		h := deltaBlockHash(base[i : i+deltaBlock])
+		if _, ok := index[h]; !ok {
+			index[h] = i
+		}
+	}
+
+	var pow uint64 = 1
+	for i := 0; i < deltaBlock-1; i++ {
+		pow *= deltaPrime
+	}
+
+	pending, i := 0, 0
+	var h uint64
+	if len(target) >= deltaBlock {
+		h = deltaBlockHash(target[:deltaBlock])
+	}
+
+	for i+deltaBlock <= len(target) {
+		off, ok := index[h]
+		if ok && bytes.Equal(base[off:off+deltaBlock], target[i:i+deltaBlock]) {
+			for off > 0 && i > pending && base[off-1] == target[i-1] {
+				off--
+				i--
+			}
+			n := 0
+			for off+n < len(base) && i+n < len(target) && base[off+n] == target[i+n] {
+				n++
+			}
+
+			out = appendDeltaInsert(out, target[pending:i])
+			out = append(out, deltaOpCopy)
+			out = binary.AppendUvarint(out, uint64(off))
+			out = binary.AppendUvarint(out, uint64(n))
+
+			i += n
+			pending = i
+			if i+deltaBlock <= len(target) {
+				h = deltaBlockHash(target[i : i+deltaBlock])
+			}
+			continue
+		}
+
+		if i+deltaBlock < len(target) {
+			h = (h-uint64(target[i])*pow)*deltaPrime + uint64(target[i+deltaBlock])
+		}
+		i++
+	}
+
+	return appendDeltaInsert(out, target[pending:])
+}
+
+func appendDeltaInsert(out, literal []byte) []byte {
+	if len(literal) == 0 {
+		return out
+	}
+	out = append(out, deltaOpInsert)
+	out = binary.AppendUvarint(out, uint64(len(literal)))
+	return append(out, literal...)
+}
+
+func applyDelta(base, delta []byte) ([]byte, error) {
+	size, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return nil, errBadDelta
+	}
+	delta = delta[n:]
+
+	// Every copy takes at least three bytes of the delta and yields at most
+	// the whole base, and inserts yield less than they take, so larger sizes
+	// are corrupt. The rest is only allocated up to the size of the inputs.
+	if size > uint64(len(delta))+uint64(len(delta)/3)*uint64(len(base)) {
+		return nil, errBadDelta
+	}
+	out := make([]byte, 0, min(size, uint64(len(base)+len(delta))))
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		switch op {
+		case deltaOpCopy:
+			off, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, errBadDelta
+			}
+			delta = delta[n:]
+			length, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, errBadDelta
+			}
+			delta = delta[n:]
+			if off > uint64(len(base)) || length > uint64(len(base))-off {
+				return nil, errBadDelta
+			}
+			out = append(out, base[off:off+length]...)
+		case deltaOpInsert:
+			length, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, errBadDelta
+			}
+			delta = delta[n:]
+			if length > uint64(len(delta)) {
+				return nil, errBadDelta
+			}
+			out = append(out, delta[:length]...)
+			delta = delta[length:]
+		default:
+			return nil, errBadDelta
+		}
+	}
+
+	if uint64(len(out)) != size {
+		return nil, errBadDelta
+	}
+	return out, nil
+}
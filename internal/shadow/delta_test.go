@@ -0,0 +1,76 @@
+package shadow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	base := []byte(strings.Repeat("server:\n  port: 8080\n  host: localhost\n", 200))
+	target := bytes.Replace(base, []byte("8080"), []byte("9090"), 3)
+	target = append([]byte("# header\n"), target...)
+
+	delta := encodeDelta(base, target)
+	if len(delta) >= len(target)/4 {
+		t.Errorf("expected compact delta, got %d bytes for %d byte target", len(delta), len(target))
+	}
+
+	decoded, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatalf("applyDelta failed: %v", err)
+	}
+	if !bytes.Equal(decoded, target) {
+		t.Error("delta did not reproduce target")
+	}
+}
+
+func TestDeltaRoundTrip_EdgeCases(t *testing.T) {
+	cases := []struct {
+		name   string
+		base   string
+		target string
+	}{
+		{"empty both", "", ""},
+		{"empty base", "", "new content"},
+		{"empty target", "old content", ""},
+		{"unrelated", strings.Repeat("a", 100), strings.Repeat("b", 100)},
+		{"identical", strings.Repeat("same block ", 50), strings.Repeat("same block ", 50)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			delta := encodeDelta([]byte(tc.base), []byte(tc.target))
+			decoded, err := applyDelta([]byte(tc.base), delta)
+			if err != nil {
+				t.Fatalf("applyDelta failed: %v", err)
+			}
+			if string(decoded) != tc.target {
+				t.Errorf("expected %q, got %q", tc.target, string(decoded))
+			}
+		})
+	}
+}
+
+func TestApplyDelta_Malformed(t *testing.T) {
+	base := []byte("base content")
+
+	bad := [][]byte{
+		{},
+		{5, deltaOpCopy, 100, 5},
+		{5, deltaOpInsert, 10, 'a'},
+		{5, 'X'},
+		{3, deltaOpInsert, 1, 'a'},
+		// A size no operation could produce, which must not be allocated.
+		binary.AppendUvarint(nil, 1<<62),
+		append(binary.AppendUvarint(nil, 1<<40), deltaOpCopy, 0, 12),
+	}
+
+	for _, delta := range bad {
+		if _, err := applyDelta(base, delta); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("expected ErrCorrupt for delta %v, got %v", delta, err)
+		}
+	}
+}
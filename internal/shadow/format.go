@@ -0,0 +1,400 @@
+package shadow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Repository formats. FORMAT in the repository root records the format the
+// repository is in; repositories predating it are recognized by their layout.
+//
+//	1: snapshots named after version IDs, metadata in list.json
+//	2: content-addressed snapshots, metadata in list.json
+//	3: per-path manifests and index.json
+//	4: blobs and manifests of encrypted repositories named by keyed hashes
+const (
+	formatName    = "FORMAT"
+	CurrentFormat = 4
+)
+
+// ErrFutureFormat is returned for repositories written by a newer version.
+var ErrFutureFormat = errors.New("repository was written by a newer version of shadow")
+
+// Migration upgrades a repository from one format to the next.
+type Migration struct {
+	From        int
+	To          int
+	Description string
+
+	run func(s *Store) error
+}
+
+var migrations = []Migration{
+	{From: 1, To: 2, Description: "move snapshots into the content-addressed layout", run: (*Store).migrateSnapshots},
+	{From: 2, To: 3, Description: "split list.json into per-file manifests", run: (*Store).migrateList},
+	{From: 3, To: 4, Description: "rename encrypted snapshots and manifests after keyed hashes", run: (*Store).migrateNames},
+}
+
+// plainBackend returns the backend underneath any encryption. The format
+// marker is kept in plaintext so that it can be checked before unlocking.
+func plainBackend(backend Backend) Backend {
+	if b, ok := backend.(*EncryptedBackend); ok {
+		return b.inner
+	}
+	return backend
+}
+
+// readFormat returns the format recorded in the repository, or 0 if there is
+// no marker.
+func readFormat(backend Backend) (int, error) {
+	data, err := plainBackend(backend).LoadMeta(formatName)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	format, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || format < 1 {
+		return 0, fmt.Errorf("failed to parse %s: invalid format %q", formatName, strings.TrimSpace(string(data)))
+	}
+	return format, nil
+}
+
+func writeFormat(backend Backend, format int) error {
+	return plainBackend(backend).StoreMeta(formatName, []byte(strconv.Itoa(format)+"\n"))
+}
+
+func futureFormatError(format int) error {
+	return fmt.Errorf("%w: format %d, this version supports up to %d", ErrFutureFormat, format, CurrentFormat)
+}
+
+// CheckFormat fails with ErrFutureFormat if the repository in backend is in
+// a format newer than this version understands. A damaged marker is left for
+// Format to report, so that the repository can still be repaired.
+func CheckFormat(backend Backend) error {
+	format, err := readFormat(backend)
+	if err == nil && format > CurrentFormat {
+		return futureFormatError(format)
+	}
+	return nil
+}
+
+// Format returns the format of the repository. Empty repositories are in the
+// current format.
+func (s *Store) Format() (int, error) {
+	format, err := readFormat(s.backend)
+	if err != nil || format != 0 {
+		return format, err
+	}
+
+	if _, err := loadIndex(s.backend); err == nil {
+		return 3, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	if local, ok := s.backend.(*LocalBackend); ok && hasFlatSnapshots(local) {
+		return 1, nil
+	}
+	if _, err := s.backend.LoadMeta(legacyListName); err == nil {
+		return 2, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	return CurrentFormat, nil
+}
+
+// PendingMigrations returns the steps bringing the repository to the current
+// format.
+func (s *Store) PendingMigrations() ([]Migration, error) {
+	format, err := s.Format()
+	if err != nil {
+		return nil, err
+	}
+	if format > CurrentFormat {
+		return nil, futureFormatError(format)
+	}
+
+	var steps []Migration
+	for _, m := range migrations {
+		if m.From >= format {
+			steps = append(steps, m)
+		}
+	}
+	return steps, nil
+}
+
+// NeedsMigrate reports whether the repository is in an older format.
+func (s *Store) NeedsMigrate() bool {
+	steps, err := s.PendingMigrations()
+	return err == nil && len(steps) > 0
+}
+
+// Migrate upgrades the repository to the current format one step at a time,
+// after backing up its metadata. The format is recorded after every step so
+// that an interrupted migration resumes where it stopped. It returns the
+// steps that were run. The repository must be locked exclusively.
+func (s *Store) Migrate() ([]Migration, error) {
+	steps, err := s.PendingMigrations()
+	if err != nil || len(steps) == 0 {
+		return nil, err
+	}
+
+	if _, err := s.backupMeta(fmt.Sprintf("format-%d", steps[0].From)); err != nil {
+		return nil, fmt.Errorf("failed to back up metadata: %w", err)
+	}
+
+	for i, m := range steps {
+		if err := m.run(s); err != nil {
+			return steps[:i], fmt.Errorf("failed to migrate from format %d to %d: %w", m.From, m.To, err)
+		}
+		if err := writeFormat(s.backend, m.To); err != nil {
+			return steps[:i], fmt.Errorf("failed to write %s: %w", formatName, err)
+		}
+	}
+	return steps, nil
+}
+
+// hasFlatSnapshots reports whether snapshots/ holds files directly, as in
+// format 1.
+func hasFlatSnapshots(local *LocalBackend) bool {
+	entries, err := os.ReadDir(local.snapshotsDir())
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() && filepath.Ext(e.Name()) != ".tmp" {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateSnapshots moves snapshots named after version IDs into the
+// content-addressed layout. Versions without a recorded hash get the hash of
+// their snapshot, which is saved before anything is moved. Only local
+// repositories predate the content-addressed layout.
+func (s *Store) migrateSnapshots() error {
+	local, ok := s.backend.(*LocalBackend)
+	if !ok {
+		return nil
+	}
+
+	entries, err := os.ReadDir(local.snapshotsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	list, err := loadLegacyList(s.backend)
+	if err != nil {
+		return err
+	}
+
+	hashes := make(map[string]string)
+	for _, e := range entries {
+		if !e.Type().IsRegular() || filepath.Ext(e.Name()) == ".tmp" {
+			continue
+		}
+		hash, err := HashFile(filepath.Join(local.snapshotsDir(), e.Name()))
+		if err != nil {
+			return err
+		}
+		hashes[e.Name()] = hash
+	}
+
+	changed := false
+	for i := range list.Files {
+		for j := range list.Files[i].Versions {
+			v := &list.Files[i].Versions[j]
+			if hash, ok := hashes[v.ID]; ok && v.Hash == "" {
+				v.Hash = hash
+				changed = true
+			}
+		}
+	}
+	if changed {
+		if err := saveLegacyList(s.backend, list); err != nil {
+			return err
+		}
+	}
+
+	for name, hash := range hashes {
+		legacyPath := filepath.Join(local.snapshotsDir(), name)
+		if s.Has(hash) {
+			if err := os.Remove(legacyPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		dst := local.Path(hash)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(legacyPath, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateList splits list.json into per-path manifests and an index.
+func (s *Store) migrateList() error {
+	if _, err := s.backend.LoadMeta(legacyListName); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	list, err := loadLegacyList(s.backend)
+	if err != nil {
+		return err
+	}
+	if err := s.SaveList(list); err != nil {
+		return err
+	}
+	if err := s.backend.DeleteMeta(legacyListName); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// migrateNames moves the blobs and manifests of an encrypted repository from
+// plain hashes to the names the backend derives for them. Blobs are sealed
+// again since their name is bound into them. Blobs no version references keep
+// their names, and are left to GC. Plain repositories are not changed.
+func (s *Store) migrateNames() error {
+	b, ok := s.backend.(*EncryptedBackend)
+	if !ok {
+		return nil
+	}
+
+	list, err := s.LoadList()
+	if err != nil {
+		return err
+	}
+	for _, f := range list.Files {
+		for _, v := range f.Versions {
+			for _, key := range v.Objects() {
+				for _, codec := range codecs {
+					if err := b.rename(key + codecExt(codec)); err != nil {
+						return fmt.Errorf("failed to rename %s: %w", key, err)
+					}
+				}
+			}
+		}
+	}
+	return s.renameManifests()
+}
+
+// renameManifests moves the manifests of the repository, including those in
+// backups, to the names manifestName gives them, and rewrites the documents
+// listing them. Old manifests are only removed once nothing lists them.
+func (s *Store) renameManifests() error {
+	renamed, err := s.renameIndexed("")
+	if err != nil {
+		return err
+	}
+	if err := s.deleteMeta("", renamed); err != nil {
+		return err
+	}
+
+	catalog, err := loadBackups(s.backend)
+	if err != nil {
+		return err
+	}
+	all := make(map[string]map[string]string)
+	changed := false
+	for i := range catalog.Backups {
+		backup := &catalog.Backups[i]
+		renamed, err := s.renameIndexed(backupDocument(backup.Name, ""))
+		if err != nil {
+			return err
+		}
+		for j, name := range backup.Documents {
+			if n, ok := renamed[name]; ok {
+				backup.Documents[j] = n
+			}
+		}
+		all[backup.Name] = renamed
+		changed = changed || len(renamed) > 0
+	}
+	if !changed {
+		return nil
+	}
+	if err := storeBackups(s.backend, catalog); err != nil {
+		return err
+	}
+	for name, renamed := range all {
+		if err := s.deleteMeta(backupDocument(name, ""), renamed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameIndexed copies the manifests listed in the index under dir to their
+// names and rewrites the index. It returns the old names mapped to the new
+// ones. Manifests that are missing are left for Fsck to report.
+func (s *Store) renameIndexed(dir string) (map[string]string, error) {
+	data, err := s.backend.LoadMeta(dir + indexName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", dir+indexName, err)
+	}
+
+	renamed := make(map[string]string)
+	for i := range idx.Files {
+		e := &idx.Files[i]
+		name := s.manifestName(e.Path)
+		if e.Manifest == name {
+			continue
+		}
+		err := s.copyMeta(dir+e.Manifest, dir+name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		renamed[e.Manifest] = name
+		e.Manifest = name
+	}
+	if len(renamed) == 0 {
+		return nil, nil
+	}
+
+	data, err = json.MarshalIndent(&idx, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.backend.StoreMeta(dir+indexName, data); err != nil {
+		return nil, err
+	}
+	return renamed, nil
+}
+
+// deleteMeta removes the documents under dir named by the keys of renamed.
+func (s *Store) deleteMeta(dir string, renamed map[string]string) error {
+	for name := range renamed {
+		if err := s.backend.DeleteMeta(dir + name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package shadow
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormat_Detect(t *testing.T) {
+	tmpDir := t.TempDir()
+	shadowPath := filepath.Join(tmpDir, ".shadow")
+	local := NewLocalBackend(shadowPath)
+	store := NewStore(local)
+
+	if format, err := store.Format(); err != nil || format != CurrentFormat {
+		t.Errorf("empty repository: expected format %d, got %d (%v)", CurrentFormat, format, err)
+	}
+
+	local.StoreMeta(legacyListName, []byte(`{"files":[]}`))
+	if format, _ := store.Format(); format != 2 {
+		t.Errorf("list.json repository: expected format 2, got %d", format)
+	}
+
+	os.MkdirAll(filepath.Join(shadowPath, "snapshots"), 0755)
+	os.WriteFile(filepath.Join(shadowPath, "snapshots", "abcd1234"), []byte("x"), 0644)
+	if format, _ := store.Format(); format != 1 {
+		t.Errorf("flat snapshots: expected format 1, got %d", format)
+	}
+
+	os.WriteFile(filepath.Join(shadowPath, "FORMAT"), []byte("2\n"), 0644)
+	if format, _ := store.Format(); format != 2 {
+		t.Errorf("marker should take precedence, got %d", format)
+	}
+}
+
+func TestFormat_NewRepository(t *testing.T) {
+	backend := NewMemoryBackend()
+	store := NewStore(backend)
+
+	list := &List{}
+	list.AddVersion("/etc/a.conf", Version{ID: "v1", Hash: "h1"})
+	if err := store.SaveList(list); err != nil {
+		t.Fatalf("SaveList failed: %v", err)
+	}
+
+	data, err := backend.LoadMeta("FORMAT")
+	if err != nil || strings.TrimSpace(string(data)) != "4" {
+		t.Errorf("expected FORMAT 4 in new repository, got %q (%v)", data, err)
+	}
+}
+
+func TestFormat_Future(t *testing.T) {
+	backend := NewMemoryBackend()
+	backend.StoreMeta("FORMAT", []byte("99\n"))
+	store := NewStore(backend)
+
+	if err := CheckFormat(backend); !errors.Is(err, ErrFutureFormat) {
+		t.Errorf("expected ErrFutureFormat, got %v", err)
+	}
+	if _, err := store.Migrate(); !errors.Is(err, ErrFutureFormat) {
+		t.Errorf("Migrate should refuse a future format, got %v", err)
+	}
+	if store.NeedsMigrate() {
+		t.Error("a future format cannot be migrated")
+	}
+
+	backend.StoreMeta("FORMAT", []byte("three"))
+	if _, err := store.Format(); err == nil {
+		t.Error("expected error for invalid marker")
+	}
+}
+
+func TestMigrate_BackupAndResume(t *testing.T) {
+	backend := NewMemoryBackend()
+	store := NewStore(backend)
+	legacy := `{"files":[{"path":"/etc/a.conf","versions":[{"id":"v1","hash":"h1"}]}]}`
+	backend.StoreMeta(legacyListName, []byte(legacy))
+
+	steps, err := store.PendingMigrations()
+	if err != nil {
+		t.Fatalf("PendingMigrations failed: %v", err)
+	}
+	if len(steps) != 2 || steps[0].From != 2 || steps[1].To != 4 {
+		t.Fatalf("unexpected steps: %+v", steps)
+	}
+
+	// An interrupted migration left the index behind without removing list.json.
+	partial, _ := loadLegacyList(backend)
+	if err := store.SaveList(partial); err != nil {
+		t.Fatalf("SaveList failed: %v", err)
+	}
+	backend.StoreMeta("FORMAT", []byte("2\n"))
+
+	if _, err := store.Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if format, _ := readFormat(backend); format != CurrentFormat {
+		t.Errorf("expected format %d after migration, got %d", CurrentFormat, format)
+	}
+	if _, err := backend.LoadMeta(legacyListName); !errors.Is(err, os.ErrNotExist) {
+		t.Error("list.json should be removed")
+	}
+
+	catalog, err := loadBackups(backend)
+	if err != nil || len(catalog.Backups) != 1 {
+		t.Fatalf("expected one backup, got %+v (%v)", catalog, err)
+	}
+	b := catalog.Backups[0]
+	data, err := backend.LoadMeta(backupDocument(b.Name, legacyListName))
+	if err != nil || string(data) != legacy {
+		t.Errorf("backup should hold the original list.json, got %q (%v)", data, err)
+	}
+}
+
+func TestFormat_Encrypted(t *testing.T) {
+	inner := NewMemoryBackend()
+	store := NewStore(inner)
+	list := &List{}
+	list.AddVersion("/etc/a.conf", Version{ID: "v1", Hash: "h1"})
+	store.SaveList(list)
+
+	backend, err := EnableEncryption(inner, []byte("secret"))
+	if err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+
+	data, _ := inner.LoadMeta("FORMAT")
+	if strings.TrimSpace(string(data)) != "4" {
+		t.Errorf("format marker should stay readable, got %q", data)
+	}
+	if format, err := NewStore(backend).Format(); err != nil || format != CurrentFormat {
+		t.Errorf("expected format %d through encrypted backend, got %d (%v)", CurrentFormat, format, err)
+	}
+}
+
+func TestMigrate_EncryptedNames(t *testing.T) {
+	tmpDir := t.TempDir()
+	inner := NewMemoryBackend()
+	enc, _ := EnableEncryption(inner, []byte("secret"))
+	store := NewStore(enc)
+
+	srcPath := filepath.Join(tmpDir, "secrets.env")
+	os.WriteFile(srcPath, []byte("api_token: hunter2"), 0600)
+	hash, _ := HashFile(srcPath)
+	list := &List{}
+	list.AddVersion(srcPath, Version{ID: "v1", Hash: hash, Size: 18})
+	store.SaveList(list)
+
+	// Lay the repository out as format 3 did: the blob sealed under its
+	// content hash and the manifest named by the plain hash of its path.
+	inner.Put(hash, newSealReader(enc.aead, hash, strings.NewReader("api_token: hunter2")))
+	data, _ := enc.LoadMeta(store.manifestName(srcPath))
+	enc.DeleteMeta(store.manifestName(srcPath))
+	enc.StoreMeta(manifestName(srcPath), data)
+	index, _ := enc.LoadMeta(indexName)
+	enc.StoreMeta(indexName, bytes.ReplaceAll(index, []byte(store.manifestName(srcPath)), []byte(manifestName(srcPath))))
+	writeFormat(inner, 3)
+
+	steps, err := NewStore(enc).Migrate()
+	if err != nil || len(steps) != 1 {
+		t.Fatalf("Migrate failed: %+v (%v)", steps, err)
+	}
+
+	if _, err := inner.Stat(hash); !errors.Is(err, os.ErrNotExist) {
+		t.Error("blob should no longer be named by its content hash")
+	}
+	if _, err := inner.LoadMeta(manifestName(srcPath)); !errors.Is(err, os.ErrNotExist) {
+		t.Error("manifest should no longer be named by the hash of its path")
+	}
+
+	loaded, err := NewStore(enc).LoadList()
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+	r, err := NewStore(enc).OpenVersion(loaded.FindFile(srcPath), "v1")
+	if err != nil {
+		t.Fatalf("OpenVersion failed: %v", err)
+	}
+	content, _ := io.ReadAll(r)
+	r.Close()
+	if string(content) != "api_token: hunter2" {
+		t.Errorf("expected original content, got %q", content)
+	}
+
+	// The manifests in the backup taken before migrating are renamed too.
+	catalog, _ := loadBackups(enc)
+	if len(catalog.Backups) != 1 {
+		t.Fatalf("expected one backup, got %+v", catalog)
+	}
+	for _, doc := range catalog.Backups[0].Documents {
+		if doc == manifestName(srcPath) {
+			t.Error("backup lists the manifest under its old name")
+		}
+		if _, err := enc.LoadMeta(backupDocument(catalog.Backups[0].Name, doc)); err != nil {
+			t.Errorf("backup document %s missing: %v", doc, err)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package shadow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Problems reported by Fsck.
+const (
+	ProblemManifest    = "bad-manifest"  // manifest missing or unreadable
+	ProblemDuplicateID = "duplicate-id"  // version ID used twice for one path
+	ProblemMissing     = "missing"       // referenced blob does not exist
+	ProblemCorrupt     = "corrupt"       // content does not match its hash
+	ProblemSize        = "size-mismatch" // content length differs from Size
+	ProblemOrphaned    = "orphaned"      // blob no version references
+)
+
+// Problem is an inconsistency found by Fsck.
+type Problem struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path,omitempty"`
+	Version string `json:"version,omitempty"`
+	Object  string `json:"object,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+func (p Problem) String() string {
+	s := p.Kind
+	if p.Path != "" {
+		s += " " + p.Path
+	}
+	if p.Version != "" {
+		s += "@" + p.Version
+	}
+	if p.Object != "" {
+		s += " object " + p.Object
+	}
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+	return s
+}
+
+// FsckReport is the result of checking a repository.
+type FsckReport struct {
+	Files    int       `json:"files"`
+	Versions int       `json:"versions"`
+	Objects  int       `json:"objects"`
+	Problems []Problem `json:"problems"`
+}
+
+// OK reports whether no problems were found.
+func (r *FsckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// blobRef is a version referencing a blob, with the content length the blob
+// must decode to, or -1 if it holds a delta.
+type blobRef struct {
+	path    string
+	version string
+	size    int64
+}
+
+// Fsck verifies the repository: every manifest listed in the index must be
+// readable, every referenced blob must exist and decode to content matching
+// its hash and recorded size, and every stored blob must be referenced.
+// Blobs are verified by workers in parallel.
+func (s *Store) Fsck(workers int) (*FsckReport, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	report := &FsckReport{Problems: []Problem{}}
+	var mu sync.Mutex
+	addProblem := func(p Problem) {
+		mu.Lock()
+		report.Problems = append(report.Problems, p)
+		mu.Unlock()
+	}
+
+	var entries []FileEntry
+	idx, err := loadIndex(s.backend)
+	switch {
+	case err == nil:
+		for _, e := range idx.Files {
+			entry, _, err := loadManifest(s.backend, e.Manifest, e.Path)
+			if err != nil {
+				addProblem(Problem{Kind: ProblemManifest, Path: e.Path, Object: e.Manifest, Detail: err.Error()})
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		list, err := loadLegacyList(s.backend)
+		if err != nil {
+			return nil, err
+		}
+		entries = list.Files
+	default:
+		return nil, err
+	}
+
+	refs := make(map[string][]blobRef)
+	var deltas [][2]int
+	for i := range entries {
+		entry := &entries[i]
+		report.Files++
+
+		seen := make(map[string]bool)
+		for j, v := range entry.Versions {
+			report.Versions++
+			if seen[v.ID] {
+				addProblem(Problem{Kind: ProblemDuplicateID, Path: entry.Path, Version: v.ID})
+			}
+			seen[v.ID] = true
+
+			switch {
+			case len(v.Chunks) > 0:
+				var total int64
+				for _, c := range v.Chunks {
+					refs[c.Hash] = append(refs[c.Hash], blobRef{entry.Path, v.ID, c.Size})
+					total += c.Size
+				}
+				if total != v.Size {
+					addProblem(Problem{Kind: ProblemSize, Path: entry.Path, Version: v.ID,
+						Detail: fmt.Sprintf("chunks add up to %d bytes, expected %d", total, v.Size)})
+				}
+			case v.DeltaBase != "":
+				refs[v.Object()] = append(refs[v.Object()], blobRef{entry.Path, v.ID, -1})
+				deltas = append(deltas, [2]int{i, j})
+			default:
+				refs[v.Hash] = append(refs[v.Hash], blobRef{entry.Path, v.ID, v.Size})
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(refs))
+	for key := range refs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	report.Objects = len(keys)
+
+	// Paths with a broken blob are not reconstructed again below.
+	broken := make(map[string]bool)
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range jobs {
+				for _, p := range s.verifyBlob(key, refs[key]) {
+					addProblem(p)
+					mu.Lock()
+					broken[p.Path] = true
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, key := range keys {
+		jobs <- key
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, d := range deltas {
+		entry := &entries[d[0]]
+		v := entry.Versions[d[1]]
+		if broken[entry.Path] {
+			continue
+		}
+		content, err := s.readVersion(entry, d[1])
+		if err != nil {
+			addProblem(Problem{Kind: ProblemCorrupt, Path: entry.Path, Version: v.ID, Object: v.Object(), Detail: err.Error()})
+			continue
+		}
+		if int64(len(content)) != v.Size {
+			addProblem(Problem{Kind: ProblemSize, Path: entry.Path, Version: v.ID,
+				Detail: fmt.Sprintf("content is %d bytes, expected %d", len(content), v.Size)})
+		}
+	}
+
+	stored, err := s.backend.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	names := make(map[string]bool, len(refs))
+	for key := range refs {
+		names[s.blobName(key)] = true
+	}
+	for _, name := range stored {
+		key, _ := splitCodec(name)
+		if !names[key] {
+			report.Problems = append(report.Problems, Problem{Kind: ProblemOrphaned, Object: name})
+		}
+	}
+
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		a, b := report.Problems[i], report.Problems[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Version < b.Version
+	})
+	return report, nil
+}
+
+// verifyBlob checks that the blob with the given key exists and, unless it
+// holds a delta, that its content hashes to the key and has the size its
+// references expect.
+func (s *Store) verifyBlob(key string, refs []blobRef) []Problem {
+	problems := func(kind, detail string) []Problem {
+		var out []Problem
+		for _, ref := range refs {
+			out = append(out, Problem{Kind: kind, Path: ref.path, Version: ref.version, Object: key, Detail: detail})
+		}
+		return out
+	}
+
+	if !s.Has(key) {
+		return problems(ProblemMissing, "")
+	}
+
+	r, err := s.Open(key)
+	if err != nil {
+		return problems(ProblemCorrupt, err.Error())
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, r)
+	if err != nil {
+		return problems(ProblemCorrupt, err.Error())
+	}
+	if refs[0].size < 0 {
+		return nil
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != key {
+		return problems(ProblemCorrupt, "content does not match hash")
+	}
+
+	var out []Problem
+	for _, ref := range refs {
+		if ref.size != n {
+			out = append(out, Problem{Kind: ProblemSize, Path: ref.path, Version: ref.version, Object: key,
+				Detail: fmt.Sprintf("content is %d bytes, expected %d", n, ref.size)})
+		}
+	}
+	return out
+}
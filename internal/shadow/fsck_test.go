@@ -0,0 +1,119 @@
+package shadow
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func problemKinds(report *FsckReport) map[string]int {
+	kinds := make(map[string]int)
+	for _, p := range report.Problems {
+		kinds[p.Kind]++
+	}
+	return kinds
+}
+
+func newFsckStore(t *testing.T) (*Store, *List, string, string) {
+	t.Helper()
+	store, list, dump := newDeltaStore(t)
+	for i := 0; i < 3; i++ {
+		saveContent(t, store, list, dump, dumpContent(i))
+	}
+
+	store.Mode = ModeChunked
+	notes := filepath.Join(filepath.Dir(dump), "notes.txt")
+	os.WriteFile(notes, randomContent(1, 200*1024), 0644)
+	blob, err := store.Put(notes)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	list.AddVersion(notes, Version{ID: list.NewVersionID(), Size: blob.Size, Hash: blob.Hash, Chunks: blob.Chunks})
+
+	if err := store.SaveList(list); err != nil {
+		t.Fatalf("SaveList failed: %v", err)
+	}
+	return store, list, dump, notes
+}
+
+func TestFsck_Clean(t *testing.T) {
+	store, _, _, _ := newFsckStore(t)
+
+	report, err := store.Fsck(4)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if !report.OK() {
+		t.Fatalf("expected no problems, got %v", report.Problems)
+	}
+	if report.Files != 2 || report.Versions != 4 {
+		t.Errorf("unexpected counts: %+v", report)
+	}
+}
+
+func TestFsck_Problems(t *testing.T) {
+	store, list, dump, notes := newFsckStore(t)
+	backend := store.Backend()
+
+	// Corrupt the newest dump, which the older deltas are based on.
+	newest := list.FindFile(dump).Versions[0]
+	backend.Put(newest.Object(), strings.NewReader("garbage"))
+	// Lose a chunk of the notes.
+	backend.Delete(list.FindFile(notes).Versions[0].Chunks[0].Hash)
+	// Leave an unreferenced blob behind.
+	backend.Put("deadbeef", strings.NewReader("orphan"))
+
+	report, err := store.Fsck(2)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	kinds := problemKinds(report)
+	if kinds[ProblemCorrupt] != 1 {
+		t.Errorf("expected 1 corrupt snapshot, got %v", report.Problems)
+	}
+	if kinds[ProblemMissing] != 1 {
+		t.Errorf("expected 1 missing snapshot, got %v", report.Problems)
+	}
+	if kinds[ProblemOrphaned] != 1 {
+		t.Errorf("expected 1 orphaned snapshot, got %v", report.Problems)
+	}
+}
+
+func TestFsck_SizeAndDuplicateID(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(NewMemoryBackend())
+	src := filepath.Join(tmpDir, "a.txt")
+	os.WriteFile(src, []byte("hello"), 0644)
+	blob, _ := store.Put(src)
+
+	list := &List{}
+	list.AddVersion(src, Version{ID: "v1", Hash: blob.Hash, Size: blob.Size})
+	list.AddVersion(src, Version{ID: "v1", Hash: blob.Hash, Size: 42})
+	store.SaveList(list)
+
+	report, err := store.Fsck(1)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	kinds := problemKinds(report)
+	if kinds[ProblemDuplicateID] != 1 || kinds[ProblemSize] != 1 {
+		t.Errorf("expected a duplicate ID and a size mismatch, got %v", report.Problems)
+	}
+}
+
+func TestFsck_BadManifest(t *testing.T) {
+	store := NewStore(NewMemoryBackend())
+	list := &List{}
+	list.AddVersion("/etc/a.conf", Version{ID: "v1", Hash: "h1"})
+	store.SaveList(list)
+	store.Backend().DeleteMeta(manifestName("/etc/a.conf"))
+
+	report, err := store.Fsck(1)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if kinds := problemKinds(report); kinds[ProblemManifest] != 1 {
+		t.Errorf("expected a bad manifest, got %v", report.Problems)
+	}
+}
@@ -0,0 +1,173 @@
+package shadow
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// quarantineDir holds blobs set aside by GC in a local repository.
+const quarantineDir = "quarantine"
+
+// ErrNoLock is returned by GC for repositories that cannot be locked, where
+// snapshots another command is saving may look unreferenced.
+var ErrNoLock = errors.New("repository does not support locking")
+
+// GCItem is a blob or leftover file removed by GC.
+type GCItem struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Temp bool   `json:"temp,omitempty"`
+}
+
+// GCReport is the result of collecting garbage.
+type GCReport struct {
+	Reachable  int      `json:"reachable"`
+	Removed    []GCItem `json:"removed"`
+	Reclaimed  int64    `json:"reclaimed"`
+	Quarantine string   `json:"quarantine,omitempty"`
+}
+
+// GCOptions controls GC.
+type GCOptions struct {
+	// DryRun only reports what would be removed.
+	DryRun bool
+
+	// Quarantine moves garbage under quarantine/ instead of deleting it.
+	// Only local repositories support it.
+	Quarantine bool
+
+	// Force collects garbage in repositories that cannot be locked.
+	Force bool
+}
+
+// GC removes every blob that no version references, as well as temporary
+// files left behind by interrupted writes. The reachable set is computed from
+// all manifests, so GC refuses to run if any of them cannot be read. The
+// repository must be locked exclusively unless opts.DryRun is set; GC refuses
+// to remove anything from repositories that cannot be locked unless
+// opts.Force is set, as snapshots being saved are not referenced yet.
+func (s *Store) GC(opts GCOptions) (*GCReport, error) {
+	list, err := s.LoadList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata, refusing to collect garbage: %w", err)
+	}
+
+	reachable := make(map[string]bool)
+	for _, f := range list.Files {
+		for _, v := range f.Versions {
+			for _, key := range v.Objects() {
+				reachable[s.blobName(key)] = true
+			}
+		}
+	}
+
+	raw := plainBackend(s.backend)
+	local, isLocal := raw.(*LocalBackend)
+	if opts.Quarantine && !isLocal {
+		return nil, errors.New("quarantine is only supported for local repositories")
+	}
+	if _, ok := raw.(Locker); !ok && !opts.DryRun && !opts.Force {
+		return nil, ErrNoLock
+	}
+
+	report := &GCReport{Removed: []GCItem{}}
+	stored, err := raw.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	for _, name := range stored {
+		key, _ := splitCodec(name)
+		if reachable[key] {
+			report.Reachable++
+			continue
+		}
+		size, err := raw.Stat(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return report, err
+		}
+		report.Removed = append(report.Removed, GCItem{Name: name, Size: size})
+	}
+
+	if isLocal {
+		temps, err := local.tempFiles()
+		if err != nil {
+			return report, err
+		}
+		report.Removed = append(report.Removed, temps...)
+	}
+
+	for _, item := range report.Removed {
+		report.Reclaimed += item.Size
+	}
+	if opts.DryRun || len(report.Removed) == 0 {
+		return report, nil
+	}
+
+	if opts.Quarantine {
+		report.Quarantine = filepath.Join(local.root, quarantineDir, time.Now().UTC().Format("20060102T150405Z"))
+	}
+	for _, item := range report.Removed {
+		switch {
+		case opts.Quarantine:
+			err = local.quarantine(item, report.Quarantine)
+		case item.Temp:
+			err = os.Remove(filepath.Join(local.root, item.Name))
+		default:
+			err = raw.Delete(item.Name)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return report, fmt.Errorf("failed to remove %s: %w", item.Name, err)
+		}
+	}
+	return report, nil
+}
+
+// tempFiles returns the files left behind in the repository by interrupted
+// writes and broken locks, with names relative to the root.
+func (b *LocalBackend) tempFiles() ([]GCItem, error) {
+	var items []GCItem
+	err := filepath.WalkDir(b.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path == filepath.Join(b.root, quarantineDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".tmp") && !strings.HasSuffix(path, ".stale") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(b.root, path)
+		items = append(items, GCItem{Name: filepath.ToSlash(rel), Size: info.Size(), Temp: true})
+		return nil
+	})
+	return items, err
+}
+
+// quarantine moves the blob or temporary file item under dir.
+func (b *LocalBackend) quarantine(item GCItem, dir string) error {
+	src := filepath.Join(b.root, filepath.FromSlash(item.Name))
+	if !item.Temp {
+		src = b.Path(item.Name)
+	}
+	dst := filepath.Join(dir, filepath.FromSlash(item.Name))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
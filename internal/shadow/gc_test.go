@@ -0,0 +1,127 @@
+package shadow
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newGCStore(t *testing.T) (*Store, string, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	shadowPath := filepath.Join(tmpDir, ".shadow")
+	store := NewStore(NewLocalBackend(shadowPath))
+
+	src := filepath.Join(tmpDir, "a.txt")
+	os.WriteFile(src, []byte("kept content"), 0644)
+	blob, err := store.Put(src)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	list := &List{}
+	list.AddVersion(src, Version{ID: "v1", Hash: blob.Hash, Size: blob.Size})
+	if err := store.SaveList(list); err != nil {
+		t.Fatalf("SaveList failed: %v", err)
+	}
+
+	store.Backend().Put("deadbeef", strings.NewReader("orphaned content"))
+	os.WriteFile(filepath.Join(shadowPath, "index.json.tmp"), []byte("{"), 0644)
+	return store, shadowPath, blob.Hash
+}
+
+func TestGC(t *testing.T) {
+	store, shadowPath, kept := newGCStore(t)
+
+	report, err := store.GC(GCOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if len(report.Removed) != 2 || report.Reachable != 1 {
+		t.Fatalf("unexpected dry run report: %+v", report)
+	}
+	if report.Reclaimed != int64(len("orphaned content")+1) {
+		t.Errorf("unexpected reclaimed bytes: %d", report.Reclaimed)
+	}
+	if !store.Has("deadbeef") {
+		t.Fatal("dry run should not remove anything")
+	}
+
+	if _, err := store.GC(GCOptions{}); err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if store.Has("deadbeef") {
+		t.Error("orphaned blob should be removed")
+	}
+	if _, err := os.Stat(filepath.Join(shadowPath, "index.json.tmp")); !os.IsNotExist(err) {
+		t.Error("temporary file should be removed")
+	}
+	if !store.Has(kept) {
+		t.Error("referenced blob should be kept")
+	}
+}
+
+func TestGC_Quarantine(t *testing.T) {
+	store, shadowPath, _ := newGCStore(t)
+
+	report, err := store.GC(GCOptions{Quarantine: true})
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if store.Has("deadbeef") {
+		t.Error("orphaned blob should be moved out of the store")
+	}
+	if !strings.HasPrefix(report.Quarantine, filepath.Join(shadowPath, "quarantine")) {
+		t.Fatalf("unexpected quarantine directory %q", report.Quarantine)
+	}
+	data, err := os.ReadFile(filepath.Join(report.Quarantine, "deadbeef"))
+	if err != nil || string(data) != "orphaned content" {
+		t.Errorf("orphaned blob should be kept in quarantine: %q (%v)", data, err)
+	}
+
+	// Quarantined files are not garbage themselves.
+	report, err = store.GC(GCOptions{DryRun: true})
+	if err != nil || len(report.Removed) != 0 {
+		t.Errorf("expected nothing left to collect, got %+v (%v)", report, err)
+	}
+}
+
+func TestGC_RefusesUnreadableMetadata(t *testing.T) {
+	store := NewStore(NewMemoryBackend())
+	store.putBytes("shared", []byte("content"))
+	list := &List{}
+	list.AddVersion("/etc/a.conf", Version{ID: "v1", Hash: "shared"})
+	store.SaveList(list)
+	store.Backend().StoreMeta(manifestName("/etc/a.conf"), []byte("{"))
+
+	if _, err := store.GC(GCOptions{}); err == nil {
+		t.Error("GC should refuse to run with unreadable manifests")
+	}
+	if !store.Has("shared") {
+		t.Error("blob should be kept")
+	}
+}
+
+func TestGC_RefusesWithoutLock(t *testing.T) {
+	store := NewStore(NewMemoryBackend())
+	store.putBytes("orphan", []byte("content"))
+	store.SaveList(&List{})
+
+	if _, err := store.GC(GCOptions{}); !errors.Is(err, ErrNoLock) {
+		t.Errorf("expected ErrNoLock, got %v", err)
+	}
+	if !store.Has("orphan") {
+		t.Fatal("blob should be kept")
+	}
+	if report, err := store.GC(GCOptions{DryRun: true}); err != nil || len(report.Removed) != 1 {
+		t.Errorf("dry run should report the blob, got %+v (%v)", report, err)
+	}
+
+	if _, err := store.GC(GCOptions{Force: true}); err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if store.Has("orphan") {
+		t.Error("blob should be removed with Force")
+	}
+}
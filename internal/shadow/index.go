@@ -0,0 +1,368 @@
+package shadow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Metadata is sharded: each tracked path has its own manifest holding its
+// FileEntry, named after the hash of the path, and index.json summarizes all
+// tracked paths. Saving a version only rewrites the manifest of that path and
+// the index. Repositories in format 2 and earlier keep everything in
+// list.json, which Migrate converts.
+const (
+	indexName      = "index.json"
+	legacyListName = "list.json"
+)
+
+// IndexEntry summarizes one tracked path.
+type IndexEntry struct {
+	Path     string    `json:"path"`
+	Manifest string    `json:"manifest"`
+	Versions int       `json:"versions"`
+	Logical  int64     `json:"logical"`
+	Stored   int64     `json:"stored"`
+	Updated  time.Time `json:"updated"`
+
+	// Inode identifies the file the newest version was saved from, see
+	// FileMeta.Inode, and Hash is the content hash of that version.
+	Inode string `json:"inode,omitempty"`
+	Hash  string `json:"hash,omitempty"`
+}
+
+// Index lists the tracked paths of a repository.
+type Index struct {
+	Files []IndexEntry `json:"files"`
+}
+
+// FindInode returns the entry of the tracked path whose newest version was
+// saved from the file with the given inode, or nil if there is none.
+func (idx *Index) FindInode(inode string) *IndexEntry {
+	if inode == "" {
+		return nil
+	}
+	for i := range idx.Files {
+		if idx.Files[i].Inode == inode {
+			return &idx.Files[i]
+		}
+	}
+	return nil
+}
+
+func (idx *Index) find(path string) int {
+	for i := range idx.Files {
+		if idx.Files[i].Path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+// manifestName returns the metadata document holding the history of path in
+// a plain repository.
+func manifestName(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return manifestPath(hex.EncodeToString(sum[:]))
+}
+
+func manifestPath(h string) string {
+	return "manifests/" + h[:2] + "/" + h[2:] + ".json"
+}
+
+// manifestName returns the metadata document holding the history of path.
+// Encrypted repositories name it by a keyed hash, so that a path cannot be
+// confirmed by hashing it.
+func (s *Store) manifestName(path string) string {
+	if b, ok := s.backend.(*EncryptedBackend); ok {
+		return manifestPath(b.nameHash(path))
+	}
+	return manifestName(path)
+}
+
+func loadIndex(backend Backend) (*Index, error) {
+	data, err := backend.LoadMeta(indexName)
+	if err != nil {
+		return nil, err
+	}
+
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", indexName, err)
+	}
+	return &idx, nil
+}
+
+// loadLegacyList reads a repository that still keeps all metadata in
+// list.json. A repository without any metadata yields an empty list.
+func loadLegacyList(backend Backend) (*List, error) {
+	data, err := backend.LoadMeta(legacyListName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &List{Files: []FileEntry{}}, nil
+		}
+		return nil, err
+	}
+
+	var list List
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", legacyListName, err)
+	}
+	if list.Files == nil {
+		list.Files = []FileEntry{}
+	}
+	return &list, nil
+}
+
+func saveLegacyList(backend Backend, list *List) error {
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return backend.StoreMeta(legacyListName, data)
+}
+
+func loadManifest(backend Backend, name, path string) (FileEntry, []byte, error) {
+	data, err := backend.LoadMeta(name)
+	if err != nil {
+		return FileEntry{}, nil, err
+	}
+
+	var entry FileEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return FileEntry{}, nil, fmt.Errorf("failed to parse manifest of %s: %w", path, err)
+	}
+	if entry.Path != path {
+		return FileEntry{}, nil, fmt.Errorf("manifest %s belongs to %s, not %s", name, entry.Path, path)
+	}
+	return entry, data, nil
+}
+
+// liveMetaNames returns the names of the metadata documents describing the
+// tracked paths.
+func liveMetaNames(backend Backend) ([]string, error) {
+	manifests, err := backend.ListMeta("manifests/")
+	if err != nil {
+		return nil, err
+	}
+
+	names := append([]string{legacyListName}, manifests...)
+	return append(names, indexName), nil
+}
+
+// metaNames returns the names of all metadata documents of the repository
+// other than the key and the format marker.
+func metaNames(backend Backend) ([]string, error) {
+	names, err := liveMetaNames(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := backend.ListMeta("backups/")
+	if err != nil {
+		return nil, err
+	}
+	names = append(names, backups...)
+	return append(names, backupsName), nil
+}
+
+// LoadIndex returns the summary of all tracked paths.
+func (s *Store) LoadIndex() (*Index, error) {
+	idx, err := loadIndex(s.backend)
+	if err == nil {
+		return idx, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	list, err := loadLegacyList(s.backend)
+	if err != nil {
+		return nil, err
+	}
+	idx = &Index{Files: []IndexEntry{}}
+	for i := range list.Files {
+		idx.Files = append(idx.Files, indexEntry(&list.Files[i], s.manifestName(list.Files[i].Path)))
+	}
+	return idx, nil
+}
+
+func indexEntry(entry *FileEntry, manifest string) IndexEntry {
+	usage := entry.Usage()
+	e := IndexEntry{
+		Path:     entry.Path,
+		Manifest: manifest,
+		Versions: usage.Versions,
+		Logical:  usage.Logical,
+		Stored:   usage.Stored,
+	}
+	if len(entry.Versions) > 0 {
+		e.Updated = entry.Versions[0].CreatedAt
+		e.Inode = entry.Versions[0].Meta.Inode()
+		e.Hash = entry.Versions[0].Hash
+	}
+	return e
+}
+
+// LoadList loads the history of every tracked path.
+func (s *Store) LoadList() (*List, error) {
+	idx, err := loadIndex(s.backend)
+	if errors.Is(err, os.ErrNotExist) {
+		return loadLegacyList(s.backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list := &List{Files: []FileEntry{}, loaded: make(map[string][]byte)}
+	for _, e := range idx.Files {
+		entry, data, err := loadManifest(s.backend, e.Manifest, e.Path)
+		if err != nil {
+			return nil, err
+		}
+		list.Files = append(list.Files, entry)
+		list.loaded[e.Path] = data
+	}
+	return list, nil
+}
+
+// LoadFiles loads the history of the given paths only. The returned list is
+// partial: saving it leaves other paths untouched.
+func (s *Store) LoadFiles(paths ...string) (*List, error) {
+	if _, err := loadIndex(s.backend); errors.Is(err, os.ErrNotExist) {
+		full, err := loadLegacyList(s.backend)
+		if err != nil {
+			return nil, err
+		}
+		list := &List{Files: []FileEntry{}, partial: true}
+		for _, path := range paths {
+			if entry := full.FindFile(path); entry != nil {
+				list.Files = append(list.Files, *entry)
+			}
+		}
+		return list, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	list := &List{Files: []FileEntry{}, partial: true, loaded: make(map[string][]byte)}
+	for _, path := range paths {
+		entry, data, err := loadManifest(s.backend, s.manifestName(path), path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		list.Files = append(list.Files, entry)
+		list.loaded[path] = data
+	}
+	return list, nil
+}
+
+// SaveList writes the manifests of the paths in l that changed since it was
+// loaded, removes those of paths that no longer have versions, and updates
+// the index accordingly.
+func (s *Store) SaveList(l *List) error {
+	_, err := loadIndex(s.backend)
+	hasIndex := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	created := false
+	if !hasIndex {
+		_, err := s.backend.LoadMeta(legacyListName)
+		if err == nil && l.partial {
+			return errors.New("repository metadata must be migrated before saving")
+		}
+		created = errors.Is(err, os.ErrNotExist)
+	}
+
+	var changed []*FileEntry
+	var removed []string
+
+	written := make(map[string][]byte, len(l.Files))
+	for i := range l.Files {
+		entry := &l.Files[i]
+		data, err := json.MarshalIndent(entry, "", "  ")
+		if err != nil {
+			return err
+		}
+		written[entry.Path] = data
+		if bytes.Equal(l.loaded[entry.Path], data) {
+			continue
+		}
+		if err := s.backend.StoreMeta(s.manifestName(entry.Path), data); err != nil {
+			return err
+		}
+		changed = append(changed, entry)
+	}
+
+	for path := range l.loaded {
+		if _, ok := written[path]; ok {
+			continue
+		}
+		if err := s.backend.DeleteMeta(s.manifestName(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		removed = append(removed, path)
+	}
+
+	if len(changed) == 0 && len(removed) == 0 && hasIndex {
+		l.loaded = written
+		return nil
+	}
+
+	idx, err := s.LoadIndex()
+	if err != nil {
+		return err
+	}
+	for _, entry := range changed {
+		e := indexEntry(entry, s.manifestName(entry.Path))
+		if i := idx.find(entry.Path); i >= 0 {
+			idx.Files[i] = e
+		} else {
+			idx.Files = append(idx.Files, e)
+		}
+	}
+	for _, path := range removed {
+		if i := idx.find(path); i >= 0 {
+			idx.Files = append(idx.Files[:i], idx.Files[i+1:]...)
+		}
+	}
+	if !l.partial {
+		// A full list is authoritative, including for paths it never had.
+		kept := idx.Files[:0]
+		for _, e := range idx.Files {
+			if _, ok := written[e.Path]; ok {
+				kept = append(kept, e)
+			} else if hasIndex {
+				if err := s.backend.DeleteMeta(e.Manifest); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+		}
+		idx.Files = kept
+	}
+
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := s.backend.StoreMeta(indexName, data); err != nil {
+		return err
+	}
+	if created {
+		if err := writeFormat(s.backend, CurrentFormat); err != nil {
+			return err
+		}
+	}
+
+	l.loaded = written
+	return nil
+}
@@ -0,0 +1,173 @@
+package shadow
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingBackend records which metadata documents are read and written.
+type countingBackend struct {
+	*MemoryBackend
+	loads  map[string]int
+	stores map[string]int
+}
+
+func newCountingBackend() *countingBackend {
+	return &countingBackend{MemoryBackend: NewMemoryBackend(), loads: map[string]int{}, stores: map[string]int{}}
+}
+
+func (b *countingBackend) LoadMeta(name string) ([]byte, error) {
+	b.loads[name]++
+	return b.MemoryBackend.LoadMeta(name)
+}
+
+func (b *countingBackend) StoreMeta(name string, data []byte) error {
+	b.stores[name]++
+	return b.MemoryBackend.StoreMeta(name, data)
+}
+
+func (b *countingBackend) reset() {
+	b.loads = map[string]int{}
+	b.stores = map[string]int{}
+}
+
+func TestSaveList_OnlyTouchesChangedManifests(t *testing.T) {
+	backend := newCountingBackend()
+	store := NewStore(backend)
+
+	list := &List{}
+	for _, path := range []string{"/etc/a.conf", "/etc/b.conf", "/etc/c.conf"} {
+		list.AddVersion(path, Version{ID: "v1", Hash: "h-" + path, Size: 10, CreatedAt: time.Now()})
+	}
+	if err := store.SaveList(list); err != nil {
+		t.Fatalf("SaveList failed: %v", err)
+	}
+
+	backend.reset()
+	partial, err := store.LoadFiles("/etc/b.conf")
+	if err != nil {
+		t.Fatalf("LoadFiles failed: %v", err)
+	}
+	if len(partial.Files) != 1 {
+		t.Fatalf("expected 1 loaded file, got %d", len(partial.Files))
+	}
+	partial.AddVersion("/etc/b.conf", Version{ID: "v2", Hash: "h2", Size: 20, CreatedAt: time.Now()})
+	if err := store.SaveList(partial); err != nil {
+		t.Fatalf("SaveList failed: %v", err)
+	}
+
+	for name := range backend.loads {
+		if strings.HasPrefix(name, "manifests/") && name != manifestName("/etc/b.conf") {
+			t.Errorf("unexpected load of %s", name)
+		}
+	}
+	for name := range backend.stores {
+		if name != indexName && name != manifestName("/etc/b.conf") {
+			t.Errorf("unexpected write of %s", name)
+		}
+	}
+
+	index, err := store.LoadIndex()
+	if err != nil {
+		t.Fatalf("LoadIndex failed: %v", err)
+	}
+	if len(index.Files) != 3 {
+		t.Fatalf("expected 3 indexed files, got %d", len(index.Files))
+	}
+	if e := index.Files[index.find("/etc/b.conf")]; e.Versions != 2 || e.Logical != 30 {
+		t.Errorf("index entry not updated: %+v", e)
+	}
+	total := 0
+	for _, e := range index.Files {
+		total += e.Versions
+	}
+	if total != 4 {
+		t.Errorf("expected 4 versions in total, got %d", total)
+	}
+}
+
+func TestSaveList_RemovesEmptyManifest(t *testing.T) {
+	backend := NewMemoryBackend()
+	store := NewStore(backend)
+
+	list := &List{}
+	list.AddVersion("/etc/a.conf", Version{ID: "v1", Hash: "h1"})
+	list.AddVersion("/etc/b.conf", Version{ID: "v2", Hash: "h2"})
+	store.SaveList(list)
+
+	partial, _ := store.LoadFiles("/etc/a.conf")
+	partial.RemoveVersion("/etc/a.conf", "v1")
+	if err := store.SaveList(partial); err != nil {
+		t.Fatalf("SaveList failed: %v", err)
+	}
+
+	if _, err := backend.LoadMeta(manifestName("/etc/a.conf")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("manifest of untracked file should be removed")
+	}
+	index, _ := store.LoadIndex()
+	if len(index.Files) != 1 || index.Files[0].Path != "/etc/b.conf" {
+		t.Errorf("unexpected index after removal: %+v", index.Files)
+	}
+}
+
+func TestMigrate_LegacyList(t *testing.T) {
+	backend := NewMemoryBackend()
+	store := NewStore(backend)
+	backend.StoreMeta("list.json", []byte(`{"files":[
+		{"path":"/etc/a.conf","versions":[{"id":"v1","hash":"h1","size":3}]},
+		{"path":"/etc/b.conf","versions":[{"id":"v2","hash":"h2","size":4}]}
+	]}`))
+
+	// Readers see legacy repositories before they are migrated.
+	partial, err := store.LoadFiles("/etc/b.conf")
+	if err != nil || len(partial.Files) != 1 {
+		t.Fatalf("LoadFiles on legacy repository failed: %v", err)
+	}
+	partial.AddVersion("/etc/b.conf", Version{ID: "v3", Hash: "h3"})
+	if err := store.SaveList(partial); err == nil {
+		t.Error("saving a partial list into an unmigrated repository should fail")
+	}
+
+	if !store.NeedsMigrate() {
+		t.Fatal("expected migration to be needed")
+	}
+	if _, err := store.Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	if _, err := backend.LoadMeta("list.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Error("list.json should be removed after migration")
+	}
+	list, err := store.LoadList()
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+	if len(list.Files) != 2 || list.Files[0].Path != "/etc/a.conf" {
+		t.Errorf("unexpected files after migration: %+v", list.Files)
+	}
+}
+
+func TestRelease_PartialListChecksOtherFiles(t *testing.T) {
+	store := NewStore(NewMemoryBackend())
+	store.putBytes("shared", []byte("content"))
+
+	list := &List{}
+	list.AddVersion("/etc/a.conf", Version{ID: "v1", Hash: "shared"})
+	list.AddVersion("/etc/b.conf", Version{ID: "v2", Hash: "shared"})
+	store.SaveList(list)
+
+	partial, _ := store.LoadFiles("/etc/a.conf")
+	partial.RemoveVersion("/etc/a.conf", "v1")
+	store.SaveList(partial)
+
+	removed, _, err := store.Release(partial, "shared")
+	if err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if removed != 0 || !store.Has("shared") {
+		t.Error("blob still referenced by another file should be kept")
+	}
+}
@@ -3,7 +3,6 @@ package shadow
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"io"
 	"os"
 	"path/filepath"
@@ -11,56 +10,95 @@ import (
 )
 
 type Version struct {
-	ID        string    `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Tags      []string  `json:"tags"`
-	Notes     string    `json:"notes"`
-	Size      int64     `json:"size"`
-	Hash      string    `json:"hash"`
-}
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Tags       []string  `json:"tags"`
+	Notes      string    `json:"notes"`
+	Size       int64     `json:"size"`
+	Hash       string    `json:"hash"`
+	StoredSize int64     `json:"stored_size,omitempty"`
+	Codec      string    `json:"codec,omitempty"`
+	DeltaBase  string    `json:"delta_base,omitempty"`
+	Chunks     []Chunk   `json:"chunks,omitempty"`
+	Meta       *FileMeta `json:"meta,omitempty"`
 
-type FileEntry struct {
-	Path     string    `json:"path"`
-	Versions []Version `json:"versions"`
+	// Link is the target of a version saved from a symlink.
+	Link string `json:"link,omitempty"`
 }
 
-type List struct {
-	Files []FileEntry `json:"files"`
+// Object returns the key of the blob holding the version. Full snapshots are
+// keyed by content hash; deltas by content hash and the hash of their base.
+// Chunked versions have no single blob, see Objects.
+func (v Version) Object() string {
+	if v.DeltaBase != "" {
+		return v.Hash + "-" + v.DeltaBase
+	}
+	return v.Hash
 }
 
-func LoadList(shadowPath string) (*List, error) {
-	listPath := filepath.Join(shadowPath, "list.json")
+// Objects returns the keys of all blobs the version references.
+func (v Version) Objects() []string {
+	if len(v.Chunks) == 0 {
+		return []string{v.Object()}
+	}
+	keys := make([]string, len(v.Chunks))
+	for i, c := range v.Chunks {
+		keys[i] = c.Hash
+	}
+	return keys
+}
 
-	data, err := os.ReadFile(listPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return &List{Files: []FileEntry{}}, nil
+// objectSizes maps the keys of the blobs the version references to the
+// number of bytes each occupies on disk.
+func (v Version) objectSizes() map[string]int64 {
+	if len(v.Chunks) == 0 {
+		return map[string]int64{v.Object(): v.Stored()}
+	}
+	sizes := make(map[string]int64, len(v.Chunks))
+	for _, c := range v.Chunks {
+		if c.StoredSize == 0 {
+			sizes[c.Hash] = c.Size
+		} else {
+			sizes[c.Hash] = c.StoredSize
 		}
-		return nil, err
 	}
+	return sizes
+}
 
-	var list List
-	if err := json.Unmarshal(data, &list); err != nil {
-		return nil, err
+// Stored returns the number of bytes the version's snapshot occupies on disk.
+// Versions written before compression existed are stored as-is.
+func (v Version) Stored() int64 {
+	if v.StoredSize == 0 {
+		return v.Size
 	}
+	return v.StoredSize
+}
 
-	return &list, nil
+type FileEntry struct {
+	Path     string    `json:"path"`
+	Versions []Version `json:"versions"`
 }
 
-func (l *List) Save(shadowPath string) error {
-	listPath := filepath.Join(shadowPath, "list.json")
-	tmpPath := listPath + ".tmp"
+type List struct {
+	Files []FileEntry `json:"files"`
 
-	data, err := json.MarshalIndent(l, "", "  ")
-	if err != nil {
-		return err
-	}
+	// partial is set when only some paths were loaded, see Store.LoadFiles.
+	partial bool
 
-	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
-		return err
-	}
+	// loaded holds the manifests as loaded, to skip rewriting unchanged ones
+	// and to notice paths whose last version was removed.
+	loaded map[string][]byte
+}
+
+// LoadList loads the history of every path tracked in the local repository
+// at shadowPath.
+func LoadList(shadowPath string) (*List, error) {
+	return NewStore(NewLocalBackend(shadowPath)).LoadList()
+}
 
-	return os.Rename(tmpPath, listPath)
+// Save writes the list to the local repository at shadowPath.
+func (l *List) Save(shadowPath string) error {
+	return NewStore(NewLocalBackend(shadowPath)).SaveList(l)
 }
 
 func (l *List) FindFile(path string) *FileEntry {
@@ -86,6 +124,52 @@ func (l *List) AddVersion(path string, version Version) {
 	})
 }
 
+// Rename moves the history of from to to, merging it with any history to
+// already has. It reports whether from was tracked.
+func (l *List) Rename(from, to string) bool {
+	src := l.FindFile(from)
+	if src == nil || from == to {
+		return false
+	}
+	dst := l.FindFile(to)
+	if dst == nil {
+		src.Path = to
+		return true
+	}
+
+	// Merge newest first, keeping the order within each history so that
+	// delta bases stay newer than their dependents.
+	a, b := dst.Versions, src.Versions
+	merged := make([]Version, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if b[0].CreatedAt.After(a[0].CreatedAt) {
+			merged = append(merged, b[0])
+			b = b[1:]
+		} else {
+			merged = append(merged, a[0])
+			a = a[1:]
+		}
+	}
+	merged = append(append(merged, a...), b...)
+
+	seen := make(map[string]bool, len(merged))
+	for i := range merged {
+		if seen[merged[i].ID] {
+			merged[i].ID = l.NewVersionID()
+		}
+		seen[merged[i].ID] = true
+	}
+	dst.Versions = merged
+
+	for i := range l.Files {
+		if l.Files[i].Path == from {
+			l.Files = append(l.Files[:i], l.Files[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
 func (l *List) RemoveVersion(path string, versionID string) bool {
 	for i := range l.Files {
 		if l.Files[i].Path == path {
@@ -104,11 +188,7 @@ func (l *List) RemoveVersion(path string, versionID string) bool {
 	return false
 }
 
-func GenerateVersionID(content []byte) string {
-	hash := sha256.Sum256(content)
-	return hex.EncodeToString(hash[:4])
-}
-
+// CopyFile copies src to dst along with its mode and modification time.
 func CopyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
 	if err != nil {
@@ -116,18 +196,28 @@ func CopyFile(src, dst string) error {
 	}
 	defer sourceFile.Close()
 
+	meta, err := ReadMeta(src, false)
+	if err != nil {
+		return err
+	}
+
 	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
 		return err
 	}
 
-	destFile, err := os.Create(dst)
+	destFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, meta.Mode.Perm())
 	if err != nil {
 		return err
 	}
-	defer destFile.Close()
 
-	_, err = io.Copy(destFile, sourceFile)
-	return err
+	if _, err := io.Copy(destFile, sourceFile); err != nil {
+		destFile.Close()
+		return err
+	}
+	if err := destFile.Close(); err != nil {
+		return err
+	}
+	return ApplyMeta(dst, meta, false)
 }
 
 func HashFile(path string) (string, error) {
@@ -144,3 +234,74 @@ func HashFile(path string) (string, error) {
 
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
+
+// Usage summarizes the space taken by a set of versions. Logical counts the
+// size of every version; Stored counts each referenced blob once.
+type Usage struct {
+	Versions int
+	Logical  int64
+	Stored   int64
+}
+
+func (u *Usage) add(versions []Version, seen map[string]bool) {
+	for _, v := range versions {
+		u.Versions++
+		u.Logical += v.Size
+		for key, size := range v.objectSizes() {
+			if !seen[key] {
+				seen[key] = true
+				u.Stored += size
+			}
+		}
+	}
+}
+
+// Usage returns the space taken by the versions of the entry.
+func (e *FileEntry) Usage() Usage {
+	var u Usage
+	u.add(e.Versions, make(map[string]bool))
+	return u
+}
+
+// Freed returns the bytes occupied by the blobs that only the given versions
+// of the list reference, which removing them frees.
+func (l *List) Freed(versions []Version) int64 {
+	refs := make(map[string]int)
+	sizes := make(map[string]int64)
+	for _, v := range versions {
+		for _, key := range v.Objects() {
+			refs[key]++
+		}
+		for key, size := range v.objectSizes() {
+			sizes[key] = size
+		}
+	}
+	for _, f := range l.Files {
+		for _, v := range f.Versions {
+			for _, key := range v.Objects() {
+				if _, ok := refs[key]; ok {
+					refs[key]--
+				}
+			}
+		}
+	}
+
+	var freed int64
+	for key, n := range refs {
+		if n == 0 {
+			freed += sizes[key]
+		}
+	}
+	return freed
+}
+
+// Usage returns the space taken by all versions in the list. Blobs shared
+// between files are counted once.
+func (l *List) Usage() Usage {
+	var u Usage
+	seen := make(map[string]bool)
+	for _, f := range l.Files {
+		u.add(f.Versions, seen)
+	}
+	return u
+}
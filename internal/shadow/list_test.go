@@ -103,9 +103,12 @@ func TestSaveList(t *testing.T) {
 		t.Fatalf("Save failed: %v", err)
 	}
 
-	listPath := filepath.Join(shadowPath, "list.json")
-	if _, err := os.Stat(listPath); os.IsNotExist(err) {
-		t.Fatal("list.json not created")
+	if _, err := os.Stat(filepath.Join(shadowPath, "index.json")); os.IsNotExist(err) {
+		t.Fatal("index.json not created")
+	}
+	manifestPath := filepath.Join(shadowPath, filepath.FromSlash(manifestName("/tmp/test.txt")))
+	if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
+		t.Fatal("manifest not created")
 	}
 
 	loaded, err := LoadList(shadowPath)
@@ -278,24 +281,40 @@ func TestRemoveVersion_NotFound(t *testing.T) {
 	}
 }
 
-func TestGenerateVersionID(t *testing.T) {
-	content1 := []byte("test content")
-	content2 := []byte("different content")
-
-	id1 := GenerateVersionID(content1)
-	id2 := GenerateVersionID(content2)
+func TestRename(t *testing.T) {
+	now := time.Now()
+	list := &List{}
+	list.AddVersion("/old", Version{ID: "o1", CreatedAt: now.Add(-3 * time.Hour)})
+	list.AddVersion("/old", Version{ID: "o2", CreatedAt: now.Add(-time.Hour)})
+	list.AddVersion("/new", Version{ID: "n1", CreatedAt: now.Add(-2 * time.Hour)})
+	list.AddVersion("/new", Version{ID: "o2", CreatedAt: now})
 
-	if id1 == id2 {
-		t.Error("different content should generate different IDs")
+	if !list.Rename("/old", "/new") {
+		t.Fatal("expected Rename to find /old")
+	}
+	if list.FindFile("/old") != nil || len(list.Files) != 1 {
+		t.Fatalf("expected histories to be merged, got %+v", list.Files)
 	}
 
-	if len(id1) != 8 {
-		t.Errorf("expected version ID length 8, got %d", len(id1))
+	versions := list.FindFile("/new").Versions
+	if len(versions) != 4 {
+		t.Fatalf("expected 4 versions, got %d", len(versions))
+	}
+	for i := 1; i < len(versions); i++ {
+		if versions[i].CreatedAt.After(versions[i-1].CreatedAt) {
+			t.Errorf("versions not newest first: %+v", versions)
+		}
+	}
+	if versions[0].ID != "o2" || versions[2].ID == "o2" {
+		t.Errorf("duplicate version ID should be reassigned, got %+v", versions)
 	}
 
-	id1Again := GenerateVersionID(content1)
-	if id1 != id1Again {
-		t.Error("same content should generate same ID")
+	if list.Rename("/missing", "/new") {
+		t.Error("expected Rename to return false for untracked path")
+	}
+	list.Rename("/new", "/moved")
+	if list.FindFile("/moved") == nil {
+		t.Error("expected history under /moved")
 	}
 }
 
@@ -323,6 +342,27 @@ func TestCopyFile(t *testing.T) {
 	}
 }
 
+func TestCopyFile_PreservesMode(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	srcPath := filepath.Join(tmpDir, "run.sh")
+	dstPath := filepath.Join(tmpDir, "copy.sh")
+	os.WriteFile(srcPath, []byte("#!/bin/sh\n"), 0755)
+
+	if err := CopyFile(srcPath, dstPath); err != nil {
+		t.Fatalf("CopyFile failed: %v", err)
+	}
+
+	src, _ := os.Stat(srcPath)
+	dst, err := os.Stat(dstPath)
+	if err != nil {
+		t.Fatalf("failed to stat destination file: %v", err)
+	}
+	if dst.Mode() != src.Mode() || !dst.ModTime().Equal(src.ModTime()) {
+		t.Errorf("expected mode %v and mtime %v, got %v and %v", src.Mode(), src.ModTime(), dst.Mode(), dst.ModTime())
+	}
+}
+
 func TestCopyFile_SourceNotFound(t *testing.T) {
 	tmpDir := t.TempDir()
 	err := CopyFile("/nonexistent/source.txt", filepath.Join(tmpDir, "dest.txt"))
@@ -365,3 +405,63 @@ func TestHashFile_NotFound(t *testing.T) {
 		t.Error("expected error when file doesn't exist")
 	}
 }
+
+func TestUsage(t *testing.T) {
+	list := &List{
+		Files: []FileEntry{
+			{
+				Path: "/tmp/a.txt",
+				Versions: []Version{
+					{ID: "v1", Hash: "h1", Size: 100, StoredSize: 40},
+					{ID: "v2", Hash: "h1", Size: 100, StoredSize: 40},
+					{ID: "v3", Hash: "h2", Size: 50},
+				},
+			},
+			{
+				Path:     "/tmp/b.txt",
+				Versions: []Version{{ID: "v4", Hash: "h1", Size: 100, StoredSize: 40}},
+			},
+		},
+	}
+
+	file := list.Files[0].Usage()
+	if file.Versions != 3 || file.Logical != 250 || file.Stored != 90 {
+		t.Errorf("unexpected file usage: %+v", file)
+	}
+
+	total := list.Usage()
+	if total.Versions != 4 || total.Logical != 350 || total.Stored != 90 {
+		t.Errorf("unexpected total usage: %+v", total)
+	}
+}
+
+func TestFreed(t *testing.T) {
+	list := &List{
+		Files: []FileEntry{
+			{
+				Path: "/tmp/a.txt",
+				Versions: []Version{
+					{ID: "v1", Hash: "h1", Size: 100, StoredSize: 40},
+					{ID: "v2", Hash: "h1", Size: 100, StoredSize: 40},
+					{ID: "v3", Hash: "h2", Size: 50, StoredSize: 20},
+				},
+			},
+			{
+				Path:     "/tmp/b.txt",
+				Versions: []Version{{ID: "v4", Hash: "h3", Size: 100, StoredSize: 30}},
+			},
+		},
+	}
+	a := list.Files[0].Versions
+
+	// h1 is still referenced by v2.
+	if freed := list.Freed(a[:1]); freed != 0 {
+		t.Errorf("expected nothing freed, got %d", freed)
+	}
+	if freed := list.Freed(a[:2]); freed != 40 {
+		t.Errorf("expected 40 bytes freed, got %d", freed)
+	}
+	if freed := list.Freed([]Version{a[1], a[2], list.Files[1].Versions[0]}); freed != 50 {
+		t.Errorf("expected 50 bytes freed, got %d", freed)
+	}
+}
@@ -0,0 +1,199 @@
+package shadow
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalBackend keeps blobs and metadata in a .shadow directory. Blobs live
+// under snapshots/, fanned out into subdirectories named after the first two
+// characters of their key.
+type LocalBackend struct {
+	root string
+}
+
+// NewLocalBackend returns the backend for the .shadow directory at root.
+func NewLocalBackend(root string) *LocalBackend {
+	return &LocalBackend{root: root}
+}
+
+// Root returns the .shadow directory of the backend.
+func (b *LocalBackend) Root() string {
+	return b.root
+}
+
+func (b *LocalBackend) snapshotsDir() string {
+	return filepath.Join(b.root, "snapshots")
+}
+
+// Path returns the file holding the blob with the given key.
+func (b *LocalBackend) Path(key string) string {
+	if len(key) < 3 {
+		return filepath.Join(b.snapshotsDir(), key)
+	}
+	return filepath.Join(b.snapshotsDir(), key[:2], key[2:])
+}
+
+func (b *LocalBackend) Put(key string, r io.Reader) error {
+	dst := b.Path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+// Stage writes r to a temporary file under snapshots/, which GC removes if it
+// is left behind.
+func (b *LocalBackend) Stage(r io.Reader) (func(string) error, func() error, error) {
+	if err := os.MkdirAll(b.snapshotsDir(), 0755); err != nil {
+		return nil, nil, err
+	}
+
+	tmp, err := os.CreateTemp(b.snapshotsDir(), "staged-*.tmp")
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, nil, err
+	}
+
+	commit := func(key string) error {
+		dst := b.Path(key)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), dst)
+	}
+	abort := func() error {
+		if err := os.Remove(tmp.Name()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return commit, abort, nil
+}
+
+func (b *LocalBackend) Get(key string) (io.ReadCloser, error) {
+	return os.Open(b.Path(key))
+}
+
+func (b *LocalBackend) Stat(key string) (int64, error) {
+	stat, err := os.Stat(b.Path(key))
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+func (b *LocalBackend) Delete(key string) error {
+	return os.Remove(b.Path(key))
+}
+
+func (b *LocalBackend) List() ([]string, error) {
+	dirs, err := os.ReadDir(b.snapshotsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var keys []string
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(b.snapshotsDir(), dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if !f.Type().IsRegular() || strings.HasSuffix(f.Name(), ".tmp") {
+				continue
+			}
+			keys = append(keys, dir.Name()+f.Name())
+		}
+	}
+	return keys, nil
+}
+
+func (b *LocalBackend) LoadMeta(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(b.root, filepath.FromSlash(name)))
+}
+
+func (b *LocalBackend) StoreMeta(name string, data []byte) error {
+	path := filepath.Join(b.root, filepath.FromSlash(name))
+	tmpPath := path + ".tmp"
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (b *LocalBackend) DeleteMeta(name string) error {
+	return os.Remove(filepath.Join(b.root, filepath.FromSlash(name)))
+}
+
+func (b *LocalBackend) ListMeta(prefix string) ([]string, error) {
+	// Walk from the directory containing prefix, not the whole repository.
+	dir := b.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = filepath.Join(b.root, filepath.FromSlash(prefix[:i]))
+	}
+
+	var names []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if path == b.snapshotsDir() {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.root, path)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	return names, err
+}
@@ -0,0 +1,175 @@
+package shadow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockPollInterval is how often a blocked lock attempt is retried.
+var lockPollInterval = 50 * time.Millisecond
+
+// ErrLocked is returned when a repository lock could not be taken in time.
+var ErrLocked = errors.New("repository is locked")
+
+// errLockUnsupported is returned by tryFlock on file systems without flock.
+var errLockUnsupported = errors.New("file locking not supported")
+
+// Locker is implemented by backends that support advisory locking across
+// processes. Backends without it rely on conditional metadata writes.
+type Locker interface {
+	// Lock takes a shared or exclusive lock on the repository, waiting at
+	// most timeout, and returns a function releasing it.
+	Lock(exclusive bool, timeout time.Duration) (func() error, error)
+}
+
+// lockOwner identifies the process holding an exclusive lock.
+type lockOwner struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Created time.Time `json:"created"`
+}
+
+func currentOwner() lockOwner {
+	host, _ := os.Hostname()
+	return lockOwner{PID: os.Getpid(), Host: host, Created: time.Now()}
+}
+
+func readOwner(path string) (lockOwner, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return lockOwner{}, false
+	}
+	var owner lockOwner
+	if err := json.Unmarshal(data, &owner); err != nil || owner.PID == 0 {
+		return lockOwner{}, false
+	}
+	return owner, true
+}
+
+// stale reports whether the owner is a process on this host that no longer
+// exists.
+func (o lockOwner) stale() bool {
+	host, _ := os.Hostname()
+	return o.Host == host && !processAlive(o.PID)
+}
+
+func (o lockOwner) same(other lockOwner) bool {
+	return o.PID == other.PID && o.Host == other.Host && o.Created.Equal(other.Created)
+}
+
+func lockedError(path string) error {
+	if owner, ok := readOwner(path); ok {
+		return fmt.Errorf("%w by pid %d on %s since %s", ErrLocked, owner.PID, owner.Host, owner.Created.Format("2006-01-02 15:04:05"))
+	}
+	return ErrLocked
+}
+
+// Lock takes an advisory lock on the .shadow directory. It uses flock on the
+// lock file and falls back to an exclusive pid file on file systems without
+// flock support, breaking it when its owner has died. Shared locks on a
+// repository that does not exist yet are no-ops.
+func (b *LocalBackend) Lock(exclusive bool, timeout time.Duration) (func() error, error) {
+	if !exclusive {
+		if _, err := os.Stat(b.root); os.IsNotExist(err) {
+			return func() error { return nil }, nil
+		}
+	}
+	if err := os.MkdirAll(b.root, 0755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(b.root, "lock")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := tryFlock(file, exclusive)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, errLockUnsupported) {
+			file.Close()
+			return lockPidFile(path+".pid", deadline)
+		}
+		if !errors.Is(err, ErrLocked) {
+			file.Close()
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, lockedError(path)
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	if exclusive {
+		// Record the owner for error messages; readers never rely on it.
+		data, _ := json.Marshal(currentOwner())
+		file.Truncate(0)
+		file.WriteAt(data, 0)
+	}
+
+	return func() error {
+		if exclusive {
+			file.Truncate(0)
+		}
+		return file.Close()
+	}, nil
+}
+
+// lockPidFile takes an exclusive lock by creating path, removing it first if
+// it was left behind by a process that has died.
+func lockPidFile(path string, deadline time.Time) (func() error, error) {
+	data, err := json.Marshal(currentOwner())
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = file.Write(data)
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return func() error { return os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if owner, ok := readOwner(path); ok && owner.stale() {
+			breakStale(path, owner)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, lockedError(path)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// breakStale removes the pid file at path if it still belongs to owner. The
+// file is moved aside first so that a lock taken by a racing process in the
+// meantime is put back instead of being removed.
+func breakStale(path string, owner lockOwner) {
+	aside := fmt.Sprintf("%s.%d.stale", path, os.Getpid())
+	if err := os.Rename(path, aside); err != nil {
+		return
+	}
+	if current, ok := readOwner(aside); ok && !current.same(owner) {
+		os.Link(aside, path)
+	}
+	os.Remove(aside)
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package shadow
+
+import "os"
+
+func tryFlock(file *os.File, exclusive bool) error {
+	return errLockUnsupported
+}
+
+func processAlive(pid int) bool {
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
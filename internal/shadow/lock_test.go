@@ -0,0 +1,167 @@
+package shadow
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocalBackend_LockExclusive(t *testing.T) {
+	backend := NewLocalBackend(filepath.Join(t.TempDir(), ".shadow"))
+
+	unlock, err := backend.Lock(true, time.Second)
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+
+	_, err = backend.Lock(true, 100*time.Millisecond)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Errorf("expected lock owner in error, got %v", err)
+	}
+	if _, err := backend.Lock(false, 100*time.Millisecond); !errors.Is(err, ErrLocked) {
+		t.Errorf("shared lock should wait for exclusive holder, got %v", err)
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+
+	unlock, err = backend.Lock(true, time.Second)
+	if err != nil {
+		t.Fatalf("Lock after release failed: %v", err)
+	}
+	unlock()
+}
+
+func TestLocalBackend_LockShared(t *testing.T) {
+	root := filepath.Join(t.TempDir(), ".shadow")
+	backend := NewLocalBackend(root)
+
+	unlock, err := backend.Lock(false, time.Second)
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	unlock()
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Error("shared lock should not create a missing repository")
+	}
+
+	os.MkdirAll(root, 0755)
+	first, err := backend.Lock(false, time.Second)
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	second, err := backend.Lock(false, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("second shared lock failed: %v", err)
+	}
+
+	if _, err := backend.Lock(true, 100*time.Millisecond); !errors.Is(err, ErrLocked) {
+		t.Errorf("exclusive lock should wait for readers, got %v", err)
+	}
+
+	first()
+	second()
+}
+
+func TestLockPidFile_Stale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock.pid")
+	host, _ := os.Hostname()
+
+	// A pid file left by a live process is respected.
+	live := lockOwner{PID: os.Getpid(), Host: host, Created: time.Now()}
+	writeOwner(t, path, live)
+	if _, err := lockPidFile(path, time.Now().Add(100*time.Millisecond)); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	// One left by a process that died is broken.
+	dead := lockOwner{PID: 1 << 30, Host: host, Created: time.Now()}
+	writeOwner(t, path, dead)
+	unlock, err := lockPidFile(path, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("expected stale lock to be broken, got %v", err)
+	}
+
+	owner, _ := readOwner(path)
+	if owner.PID != os.Getpid() {
+		t.Errorf("expected lock owned by pid %d, got %d", os.Getpid(), owner.PID)
+	}
+	unlock()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("pid file should be removed on unlock")
+	}
+}
+
+func writeOwner(t *testing.T, path string, owner lockOwner) {
+	t.Helper()
+	os.WriteFile(path, []byte(fmt.Sprintf(`{"pid":%d,"host":%q,"created":%q}`,
+		owner.PID, owner.Host, owner.Created.Format(time.RFC3339Nano))), 0644)
+}
+
+func TestStoreLock_ConcurrentSaves(t *testing.T) {
+	tmpDir := t.TempDir()
+	root := filepath.Join(tmpDir, ".shadow")
+
+	const workers, saves = 8, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*saves)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			src := filepath.Join(tmpDir, fmt.Sprintf("file%d.txt", w))
+			for i := 0; i < saves; i++ {
+				os.WriteFile(src, []byte(fmt.Sprintf("worker %d save %d", w, i)), 0644)
+				errs <- lockedSave(root, src)
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("save failed: %v", err)
+		}
+	}
+
+	list, err := LoadList(root)
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+	total := 0
+	for _, f := range list.Files {
+		total += len(f.Versions)
+	}
+	if total != workers*saves {
+		t.Errorf("expected %d versions, got %d", workers*saves, total)
+	}
+}
+
+func lockedSave(root, src string) error {
+	store := NewStore(NewLocalBackend(root))
+	if err := store.Lock(true, 10*time.Second); err != nil {
+		return err
+	}
+	defer store.Close()
+
+	list, err := store.LoadList()
+	if err != nil {
+		return err
+	}
+	blob, err := store.Put(src)
+	if err != nil {
+		return err
+	}
+	list.AddVersion(src, Version{ID: list.NewVersionID(), Hash: blob.Hash, Size: blob.Size})
+	return store.SaveList(list)
+}
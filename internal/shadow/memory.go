@@ -0,0 +1,130 @@
+package shadow
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryBackend keeps blobs and metadata in memory. It is meant for tests.
+type MemoryBackend struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+	meta  map[string][]byte
+}
+
+// NewMemoryBackend returns an empty in-memory backend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		blobs: make(map[string][]byte),
+		meta:  make(map[string][]byte),
+	}
+}
+
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+func (b *MemoryBackend) Put(key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.blobs[key] = data
+	return nil
+}
+
+func (b *MemoryBackend) Get(key string) (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, ok := b.blobs[key]
+	if !ok {
+		return nil, notExist("get", key)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *MemoryBackend) Stat(key string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, ok := b.blobs[key]
+	if !ok {
+		return 0, notExist("stat", key)
+	}
+	return int64(len(data)), nil
+}
+
+func (b *MemoryBackend) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.blobs[key]; !ok {
+		return notExist("delete", key)
+	}
+	delete(b.blobs, key)
+	return nil
+}
+
+func (b *MemoryBackend) List() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	keys := make([]string, 0, len(b.blobs))
+	for key := range b.blobs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (b *MemoryBackend) LoadMeta(name string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, ok := b.meta[name]
+	if !ok {
+		return nil, notExist("load", name)
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (b *MemoryBackend) StoreMeta(name string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.meta[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (b *MemoryBackend) DeleteMeta(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.meta[name]; !ok {
+		return notExist("delete", name)
+	}
+	delete(b.meta, name)
+	return nil
+}
+
+func (b *MemoryBackend) ListMeta(prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var names []string
+	for name := range b.meta {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,110 @@
+package shadow
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// FileMeta is the metadata of a file captured along with its content. UID and
+// GID are -1 on systems without file ownership. Dev and Ino identify the file
+// on its device of Host; they are not restored.
+type FileMeta struct {
+	Mode    os.FileMode       `json:"mode"`
+	ModTime time.Time         `json:"mtime"`
+	UID     int               `json:"uid"`
+	GID     int               `json:"gid"`
+	Xattrs  map[string][]byte `json:"xattrs,omitempty"`
+	Dev     uint64            `json:"dev,omitempty"`
+	Ino     uint64            `json:"ino,omitempty"`
+	Host    string            `json:"host,omitempty"`
+}
+
+// modeMask selects the bits of a file mode that are restored.
+const modeMask = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// ReadMeta returns the metadata of the file at path, including its extended
+// attributes if xattrs is set.
+func ReadMeta(path string, xattrs bool) (*FileMeta, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &FileMeta{Mode: info.Mode() & modeMask, ModTime: info.ModTime(), UID: -1, GID: -1}
+	meta.UID, meta.GID = fileOwner(info)
+	meta.Dev, meta.Ino = fileID(info)
+	if meta.Ino != 0 {
+		meta.Host, _ = os.Hostname()
+	}
+	if xattrs {
+		if meta.Xattrs, err = readXattrs(path); err != nil {
+			return nil, fmt.Errorf("failed to read extended attributes: %w", err)
+		}
+	}
+	return meta, nil
+}
+
+// ApplyMeta sets the metadata of the file at path to meta. The owner is only
+// restored if owner is set; as with tar, failing to give the file away for
+// lack of privileges is not an error. The modification time goes last since
+// the other changes may touch it.
+func ApplyMeta(path string, meta *FileMeta, owner bool) error {
+	if owner && meta.UID >= 0 {
+		if err := os.Lchown(path, meta.UID, meta.GID); err != nil && !errors.Is(err, os.ErrPermission) {
+			return fmt.Errorf("failed to restore owner: %w", err)
+		}
+	}
+
+	if err := os.Chmod(path, meta.Mode&modeMask); err != nil {
+		return fmt.Errorf("failed to restore mode: %w", err)
+	}
+
+	for name, value := range meta.Xattrs {
+		if err := writeXattr(path, name, value); err != nil {
+			return fmt.Errorf("failed to restore extended attribute %s: %w", name, err)
+		}
+	}
+
+	if err := os.Chtimes(path, time.Time{}, meta.ModTime); err != nil {
+		return fmt.Errorf("failed to restore modification time: %w", err)
+	}
+	return nil
+}
+
+// Inode returns the device and inode number of the file along with the host
+// they belong to as "dev:ino@host", or "" if they are unknown. A repository
+// shared between machines sees the same numbers for unrelated files.
+func (m *FileMeta) Inode() string {
+	if m == nil || m.Ino == 0 || m.Host == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d@%s", m.Dev, m.Ino, m.Host)
+}
+
+// Equal reports whether m and other describe the same metadata. Files
+// rewritten by renaming a new file over them change inode on every save, so
+// Dev, Ino and Host are not compared.
+func (m *FileMeta) Equal(other *FileMeta) bool {
+	if m == nil || other == nil {
+		return m == other
+	}
+	if m.Mode != other.Mode || !m.ModTime.Equal(other.ModTime) || m.UID != other.UID || m.GID != other.GID ||
+		len(m.Xattrs) != len(other.Xattrs) {
+		return false
+	}
+	for name, value := range m.Xattrs {
+		if v, ok := other.Xattrs[name]; !ok || !bytes.Equal(v, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// MetaOnly reports whether v differs from the older version prev in its
+// metadata alone.
+func (v Version) MetaOnly(prev Version) bool {
+	return v.Hash != "" && v.Hash == prev.Hash && v.Meta != nil && prev.Meta != nil && !v.Meta.Equal(prev.Meta)
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package shadow
+
+import "os"
+
+func fileOwner(info os.FileInfo) (int, int) {
+	return -1, -1
+}
+
+func fileID(info os.FileInfo) (uint64, uint64) {
+	return 0, 0
+}
@@ -0,0 +1,119 @@
+package shadow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadMeta_ApplyMeta(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "script.sh")
+	os.WriteFile(src, []byte("#!/bin/sh\n"), 0644)
+	os.Chmod(src, 0750)
+	mtime := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(src, mtime, mtime)
+
+	meta, err := ReadMeta(src, false)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if meta.Mode != 0750 || !meta.ModTime.Equal(mtime) {
+		t.Fatalf("unexpected metadata: %+v", meta)
+	}
+
+	moved := filepath.Join(tmpDir, "moved.sh")
+	os.Rename(src, moved)
+	if other, _ := ReadMeta(moved, false); other.Inode() != meta.Inode() {
+		t.Errorf("moved file should keep its inode: %s, %s", meta.Inode(), other.Inode())
+	}
+	os.Rename(moved, src)
+
+	dst := filepath.Join(tmpDir, "restored.sh")
+	os.WriteFile(dst, []byte("#!/bin/sh\n"), 0644)
+	if err := ApplyMeta(dst, meta, true); err != nil {
+		t.Fatalf("ApplyMeta failed: %v", err)
+	}
+
+	got, err := ReadMeta(dst, false)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if !got.Equal(meta) {
+		t.Errorf("expected %+v, got %+v", meta, got)
+	}
+}
+
+func TestReadMeta_Xattrs(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "a.txt")
+	os.WriteFile(src, []byte("content"), 0644)
+	if err := writeXattr(src, "user.shadow.test", []byte("value")); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+
+	meta, err := ReadMeta(src, true)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if string(meta.Xattrs["user.shadow.test"]) != "value" {
+		t.Fatalf("expected xattr to be captured, got %v", meta.Xattrs)
+	}
+
+	dst := filepath.Join(tmpDir, "b.txt")
+	os.WriteFile(dst, []byte("content"), 0644)
+	if err := ApplyMeta(dst, meta, false); err != nil {
+		t.Fatalf("ApplyMeta failed: %v", err)
+	}
+	got, _ := ReadMeta(dst, true)
+	if !got.Equal(meta) {
+		t.Errorf("expected %+v, got %+v", meta, got)
+	}
+}
+
+func TestVersion_MetaOnly(t *testing.T) {
+	older := Version{Hash: "abc", Meta: &FileMeta{Mode: 0644}}
+	tests := []struct {
+		name string
+		v    Version
+		want bool
+	}{
+		{"mode changed", Version{Hash: "abc", Meta: &FileMeta{Mode: 0600}}, true},
+		{"nothing changed", Version{Hash: "abc", Meta: &FileMeta{Mode: 0644}}, false},
+		{"content changed", Version{Hash: "def", Meta: &FileMeta{Mode: 0600}}, false},
+		{"no metadata", Version{Hash: "abc"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.v.MetaOnly(older); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRestoreVersion_IgnoresRecordedPermissions(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(NewLocalBackend(filepath.Join(tmpDir, ".shadow")))
+
+	src := filepath.Join(tmpDir, "ssh_config")
+	os.WriteFile(src, []byte("Host *\n"), 0600)
+	blob, err := store.Put(src)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	meta, _ := ReadMeta(src, false)
+	entry := &FileEntry{Path: src, Versions: []Version{{ID: "v1", Hash: blob.Hash, Size: blob.Size, Meta: meta}}}
+
+	dst := filepath.Join(tmpDir, "restored")
+	if err := store.RestoreVersion(entry, "v1", dst); err != nil {
+		t.Fatalf("RestoreVersion failed: %v", err)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	// RestoreVersion restores no metadata, the mode included.
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644, got %o", info.Mode().Perm())
+	}
+}
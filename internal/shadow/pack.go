@@ -0,0 +1,292 @@
+package shadow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Storage modes. In delta mode the newest version of a file is kept in full
+// and older versions are stored as reverse deltas against the next newer one.
+// In chunked mode versions are split into content-defined chunks that are
+// shared across all files of the repository.
+const (
+	ModeFull    = "full"
+	ModeDelta   = "delta"
+	ModeChunked = "chunked"
+)
+
+// DefaultMaxDeltaChain is the default bound on delta chain length.
+const DefaultMaxDeltaChain = 10
+
+// ParseMode normalizes a storage mode name as found in the config file.
+func ParseMode(name string) (string, error) {
+	switch name {
+	case "", ModeFull:
+		return ModeFull, nil
+	case ModeDelta:
+		return ModeDelta, nil
+	case ModeChunked:
+		return ModeChunked, nil
+	}
+	return "", fmt.Errorf("unknown storage mode: %s", name)
+}
+
+func (e *FileEntry) versionIndex(id string) int {
+	for i := range e.Versions {
+		if e.Versions[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveBase returns the index of the version the delta of version i is
+// based on, or -1 if there is none. Bases are always newer than dependents.
+func resolveBase(entry *FileEntry, i int) int {
+	base := entry.Versions[i].DeltaBase
+	for j := i - 1; j >= 0; j-- {
+		if entry.Versions[j].Hash == base {
+			return j
+		}
+	}
+	return -1
+}
+
+// chainThrough returns the longest delta chain among version i and the
+// versions depending on it, directly or transitively.
+func chainThrough(entry *FileEntry, i int) int {
+	depth := make([]int, len(entry.Versions))
+	via := make([]bool, len(entry.Versions))
+	longest := 0
+
+	for j := range entry.Versions {
+		if entry.Versions[j].DeltaBase != "" {
+			if b := resolveBase(entry, j); b >= 0 {
+				depth[j] = depth[b] + 1
+				via[j] = via[b]
+			}
+		}
+		if j == i {
+			via[j] = true
+		}
+		if via[j] && depth[j] > longest {
+			longest = depth[j]
+		}
+	}
+	return longest
+}
+
+// readVersion reconstructs the content of version i of entry.
+func (s *Store) readVersion(entry *FileEntry, i int) ([]byte, error) {
+	v := entry.Versions[i]
+	if len(v.Chunks) > 0 {
+		r := &chunkReader{store: s, chunks: v.Chunks}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	if v.DeltaBase == "" {
+		return s.readAll(v.Hash)
+	}
+
+	b := resolveBase(entry, i)
+	if b < 0 {
+		return nil, fmt.Errorf("delta base of version %s not found", v.ID)
+	}
+
+	// A base failing its hash check is still applied, so that the content
+	// can be salvaged with Restore's Force; the check below fails then.
+	base, err := s.readVersion(entry, b)
+	if err != nil && !(errors.Is(err, ErrCorrupt) && base != nil) {
+		return nil, err
+	}
+
+	delta, err := s.readAll(v.Object())
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := applyDelta(base, delta)
+	if err != nil {
+		return nil, fmt.Errorf("version %s: %w", v.ID, err)
+	}
+
+	sum := sha256.Sum256(content)
+	if hex.EncodeToString(sum[:]) != v.Hash {
+		// The content is returned along with the error for Restore to force.
+		return content, fmt.Errorf("%w: version %s: reconstructed content does not match hash", ErrCorrupt, v.ID)
+	}
+	return content, nil
+}
+
+// OpenVersion returns the content of the version with the given ID.
+func (s *Store) OpenVersion(entry *FileEntry, id string) (io.ReadCloser, error) {
+	i := entry.versionIndex(id)
+	if i < 0 {
+		return nil, fmt.Errorf("version not found: %s", id)
+	}
+
+	v := entry.Versions[i]
+	if len(v.Chunks) > 0 {
+		return &chunkReader{store: s, chunks: v.Chunks}, nil
+	}
+	if v.DeltaBase == "" {
+		return s.Open(v.Hash)
+	}
+
+	content, err := s.readVersion(entry, i)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// encode stores content as the snapshot of v, as a delta against base when
+// that is smaller, otherwise in full. It returns the keys v referenced before.
+func (s *Store) encode(v *Version, content, base []byte, baseHash string) ([]string, error) {
+	old := v.Objects()
+
+	if base != nil {
+		delta := encodeDelta(base, content)
+		if len(delta) < len(content) {
+			blob, err := s.putBytes(v.Hash+"-"+baseHash, delta)
+			if err != nil {
+				return nil, err
+			}
+			v.DeltaBase = baseHash
+			v.Chunks = nil
+			v.StoredSize = blob.StoredSize
+			v.Codec = blob.Codec
+			return old, nil
+		}
+	}
+
+	blob, err := s.putBytes(v.Hash, content)
+	if err != nil {
+		return nil, err
+	}
+	v.DeltaBase = ""
+	v.Chunks = nil
+	v.StoredSize = blob.StoredSize
+	v.Codec = blob.Codec
+	return old, nil
+}
+
+// Pack converts the previous newest version of path into a reverse delta
+// against the version just added, unless that would exceed MaxDeltaChain.
+// It returns blob keys that may have become unreferenced; release them
+// after saving the list.
+func (s *Store) Pack(list *List, path string) ([]string, error) {
+	if s.Mode != ModeDelta {
+		return nil, nil
+	}
+
+	entry := list.FindFile(path)
+	if entry == nil || len(entry.Versions) < 2 {
+		return nil, nil
+	}
+
+	newest, prev := entry.Versions[0], &entry.Versions[1]
+	if prev.DeltaBase != "" || len(prev.Chunks) > 0 || prev.Hash == newest.Hash {
+		return nil, nil
+	}
+
+	saved := *prev
+	prev.DeltaBase = newest.Hash
+	longest := chainThrough(entry, 1)
+	*prev = saved
+	if longest > s.MaxDeltaChain {
+		return nil, nil
+	}
+
+	content, err := s.readVersion(entry, 1)
+	if err != nil {
+		return nil, err
+	}
+	base, err := s.readVersion(entry, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.encode(prev, content, base, newest.Hash)
+}
+
+// Unlink removes a version of path from list. Versions whose delta was based
+// on the removed one are rebased onto their new newer neighbour, or stored in
+// full, so every remaining version stays readable. It returns blob keys that
+// may have become unreferenced; release them after saving the list.
+func (s *Store) Unlink(list *List, path, id string) ([]string, error) {
+	entry := list.FindFile(path)
+	if entry == nil {
+		return nil, fmt.Errorf("file not tracked: %s", path)
+	}
+
+	i := entry.versionIndex(id)
+	if i < 0 {
+		return nil, fmt.Errorf("version not found: %s", id)
+	}
+
+	after := &FileEntry{Path: entry.Path}
+	after.Versions = append(after.Versions, entry.Versions[:i]...)
+	after.Versions = append(after.Versions, entry.Versions[i+1:]...)
+
+	type orphan struct {
+		index   int
+		content []byte
+	}
+	var orphans []orphan
+	for j := range after.Versions {
+		if after.Versions[j].DeltaBase == "" || resolveBase(after, j) >= 0 {
+			continue
+		}
+		src := j
+		if j >= i {
+			src = j + 1
+		}
+		content, err := s.readVersion(entry, src)
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, orphan{index: j, content: content})
+	}
+
+	released := entry.Versions[i].Objects()
+	for _, o := range orphans {
+		v := &after.Versions[o.index]
+
+		var base []byte
+		var baseHash string
+		if s.Mode == ModeDelta && o.index > 0 {
+			var err error
+			base, err = s.readVersion(after, o.index-1)
+			if err != nil {
+				return nil, err
+			}
+			baseHash = after.Versions[o.index-1].Hash
+		}
+
+		old, err := s.encode(v, o.content, base, baseHash)
+		if err != nil {
+			return nil, err
+		}
+		released = append(released, old...)
+
+		if v.DeltaBase != "" && chainThrough(after, o.index) > s.MaxDeltaChain {
+			old, err = s.encode(v, o.content, nil, "")
+			if err != nil {
+				return nil, err
+			}
+			released = append(released, old...)
+		}
+	}
+
+	if len(after.Versions) == 0 {
+		list.RemoveVersion(path, id)
+	} else {
+		entry.Versions = after.Versions
+	}
+	return released, nil
+}
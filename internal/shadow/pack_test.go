@@ -0,0 +1,223 @@
+package shadow
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newDeltaStore(t *testing.T) (*Store, *List, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	store := NewStore(NewMemoryBackend())
+	store.Mode = ModeDelta
+	return store, &List{Files: []FileEntry{}}, filepath.Join(tmpDir, "dump.sql")
+}
+
+func saveContent(t *testing.T, store *Store, list *List, path, content string) string {
+	t.Helper()
+	os.WriteFile(path, []byte(content), 0644)
+
+	blob, err := store.Put(path)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	id := list.NewVersionID()
+	list.AddVersion(path, Version{
+		ID:         id,
+		Size:       blob.Size,
+		Hash:       blob.Hash,
+		StoredSize: blob.StoredSize,
+		Codec:      blob.Codec,
+	})
+
+	garbage, err := store.Pack(list, path)
+	if err != nil {
+		t.Fatalf("Pack failed: %v", err)
+	}
+	if _, _, err := store.Release(list, garbage...); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	return id
+}
+
+func readContent(t *testing.T, store *Store, list *List, path, id string) string {
+	t.Helper()
+	r, err := store.OpenVersion(list.FindFile(path), id)
+	if err != nil {
+		t.Fatalf("OpenVersion(%s) failed: %v", id, err)
+	}
+	defer r.Close()
+	content, _ := io.ReadAll(r)
+	return string(content)
+}
+
+func dumpContent(i int) string {
+	var b strings.Builder
+	for row := 0; row < 300; row++ {
+		if row == i*7 {
+			fmt.Fprintf(&b, "INSERT INTO t VALUES (%d, 'changed in %d');\n", row, i)
+			continue
+		}
+		fmt.Fprintf(&b, "INSERT INTO t VALUES (%d, 'row %d');\n", row, row)
+	}
+	return b.String()
+}
+
+func TestParseMode(t *testing.T) {
+	if mode, _ := ParseMode(""); mode != ModeFull {
+		t.Errorf("expected default mode full, got %s", mode)
+	}
+	if mode, _ := ParseMode("delta"); mode != ModeDelta {
+		t.Errorf("expected delta mode, got %s", mode)
+	}
+	if _, err := ParseMode("bogus"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestPack_DeltaMode(t *testing.T) {
+	store, list, path := newDeltaStore(t)
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ids = append(ids, saveContent(t, store, list, path, dumpContent(i)))
+	}
+
+	entry := list.FindFile(path)
+	if entry.Versions[0].DeltaBase != "" {
+		t.Error("newest version should be stored in full")
+	}
+	for _, v := range entry.Versions[1:] {
+		if v.DeltaBase == "" {
+			t.Errorf("older version %s should be stored as delta", v.ID)
+		}
+		if v.Stored() >= v.Size/4 {
+			t.Errorf("delta for %s is not compact: %d of %d bytes", v.ID, v.Stored(), v.Size)
+		}
+		if store.Has(v.Hash) {
+			t.Errorf("full snapshot of %s should have been released", v.ID)
+		}
+	}
+
+	for i, id := range ids {
+		if got := readContent(t, store, list, path, id); got != dumpContent(i) {
+			t.Errorf("version %d not reconstructed correctly", i)
+		}
+	}
+}
+
+func TestPack_FullModeIsNoop(t *testing.T) {
+	store, list, path := newDeltaStore(t)
+	store.Mode = ModeFull
+
+	saveContent(t, store, list, path, dumpContent(0))
+	saveContent(t, store, list, path, dumpContent(1))
+
+	for _, v := range list.FindFile(path).Versions {
+		if v.DeltaBase != "" {
+			t.Error("full mode should not create deltas")
+		}
+	}
+}
+
+func TestPack_ChainBound(t *testing.T) {
+	store, list, path := newDeltaStore(t)
+	store.MaxDeltaChain = 2
+
+	var ids []string
+	for i := 0; i < 7; i++ {
+		ids = append(ids, saveContent(t, store, list, path, dumpContent(i)))
+	}
+
+	entry := list.FindFile(path)
+	for i := range entry.Versions {
+		if n := chainThrough(entry, i); n > 2 {
+			t.Errorf("version %d has delta chain %d, exceeding bound", i, n)
+		}
+	}
+
+	for i, id := range ids {
+		if got := readContent(t, store, list, path, id); got != dumpContent(i) {
+			t.Errorf("version %d not reconstructed correctly", i)
+		}
+	}
+}
+
+func TestUnlink_RebasesDependents(t *testing.T) {
+	store, list, path := newDeltaStore(t)
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ids = append(ids, saveContent(t, store, list, path, dumpContent(i)))
+	}
+
+	for _, remove := range []int{2, 4, 0} {
+		garbage, err := store.Unlink(list, path, ids[remove])
+		if err != nil {
+			t.Fatalf("Unlink failed: %v", err)
+		}
+		if _, _, err := store.Release(list, garbage...); err != nil {
+			t.Fatalf("Release failed: %v", err)
+		}
+
+		entry := list.FindFile(path)
+		if entry.Versions[0].DeltaBase != "" {
+			t.Error("newest remaining version should be stored in full")
+		}
+		for _, v := range entry.Versions {
+			i := indexOf(ids, v.ID)
+			if got := readContent(t, store, list, path, v.ID); got != dumpContent(i) {
+				t.Errorf("version %d unreadable after removing version %d", i, remove)
+			}
+		}
+	}
+
+	if len(list.FindFile(path).Versions) != 2 {
+		t.Errorf("expected 2 remaining versions, got %d", len(list.FindFile(path).Versions))
+	}
+}
+
+func TestUnlink_LastVersion(t *testing.T) {
+	store, list, path := newDeltaStore(t)
+	id := saveContent(t, store, list, path, "only version")
+	hash := list.FindFile(path).Versions[0].Hash
+
+	garbage, err := store.Unlink(list, path, id)
+	if err != nil {
+		t.Fatalf("Unlink failed: %v", err)
+	}
+	store.Release(list, garbage...)
+
+	if list.FindFile(path) != nil {
+		t.Error("file entry should be removed with its last version")
+	}
+	if store.Has(hash) {
+		t.Error("snapshot of removed version should be released")
+	}
+}
+
+func TestUnlink_NotFound(t *testing.T) {
+	store, list, path := newDeltaStore(t)
+	saveContent(t, store, list, path, "content")
+
+	if _, err := store.Unlink(list, path, "missing"); err == nil {
+		t.Error("expected error for unknown version")
+	}
+	if _, err := store.Unlink(list, "/nonexistent", "missing"); err == nil {
+		t.Error("expected error for untracked file")
+	}
+}
+
+func indexOf(ids []string, id string) int {
+	for i := range ids {
+		if ids[i] == id {
+			return i
+		}
+	}
+	return -1
+}
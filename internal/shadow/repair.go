@@ -0,0 +1,421 @@
+package shadow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RepairOptions controls Repair.
+type RepairOptions struct {
+	// Path is the path orphaned snapshots are recovered as versions of, and
+	// versions whose path was lost are attached to.
+	Path string
+
+	// DryRun only reports what would be done.
+	DryRun bool
+}
+
+// RepairReport is the result of repairing a repository.
+type RepairReport struct {
+	Backup     string    `json:"backup,omitempty"`
+	Files      int       `json:"files"`
+	Versions   int       `json:"versions"`
+	Damaged    []string  `json:"damaged"`
+	Dropped    []Problem `json:"dropped"`
+	Recovered  int       `json:"recovered"`
+	Unreadable []string  `json:"unreadable"`
+}
+
+// Repair rebuilds the metadata of the repository from whatever can still be
+// read. Versions are salvaged from damaged manifests and list.json up to the
+// point of damage, versions whose snapshots are gone are dropped, and
+// snapshots no version references are recovered as versions of opts.Path
+// with their hash and size recomputed. Snapshots of deltas cannot be
+// recovered on their own; chunks of lost chunked versions are recovered as
+// separate versions. The metadata is backed up first. The repository must be
+// locked exclusively unless opts.DryRun is set.
+func (s *Store) Repair(opts RepairOptions) (*RepairReport, error) {
+	report := &RepairReport{Damaged: []string{}, Dropped: []Problem{}, Unreadable: []string{}}
+
+	if !opts.DryRun {
+		backup, err := s.backupMeta("repair")
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up metadata: %w", err)
+		}
+		report.Backup = backup.Name
+	}
+
+	if _, err := readFormat(s.backend); err != nil {
+		report.Damaged = append(report.Damaged, formatName)
+	}
+
+	list := &List{Files: []FileEntry{}}
+	add := func(entry FileEntry) {
+		path := entry.Path
+		if path == "" {
+			path = opts.Path
+		}
+		target := list.FindFile(path)
+		if target == nil {
+			list.Files = append(list.Files, FileEntry{Path: path})
+			target = &list.Files[len(list.Files)-1]
+		}
+		for _, v := range entry.Versions {
+			if v.ID == "" || list.hasVersionID(v.ID) {
+				v.ID = list.NewVersionID()
+			}
+			target.Versions = append(target.Versions, v)
+		}
+	}
+
+	legacy, err := s.salvageLegacyList(opts, report)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range legacy {
+		add(entry)
+	}
+
+	manifests, err := s.backend.ListMeta("manifests/")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range manifests {
+		data, err := s.backend.LoadMeta(name)
+		if err != nil {
+			report.Damaged = append(report.Damaged, name)
+			continue
+		}
+		entry, damaged := salvageEntry(data)
+		if damaged {
+			report.Damaged = append(report.Damaged, name)
+		}
+		add(entry)
+	}
+
+	// Paths in the index whose manifest is gone cannot be brought back.
+	idx, err := loadIndex(s.backend)
+	indexBroken := err != nil && !errors.Is(err, os.ErrNotExist)
+	if indexBroken {
+		report.Damaged = append(report.Damaged, indexName)
+	} else if err == nil {
+		for _, e := range idx.Files {
+			if list.FindFile(e.Path) == nil {
+				report.Dropped = append(report.Dropped, Problem{Kind: ProblemManifest, Path: e.Path, Object: e.Manifest})
+			}
+		}
+	}
+
+	sortVersions(list)
+	s.dropBroken(list, report)
+	if err := s.recoverOrphans(list, opts, report); err != nil {
+		return nil, err
+	}
+	sortVersions(list)
+
+	for i := range list.Files {
+		report.Versions += len(list.Files[i].Versions)
+	}
+	report.Files = len(list.Files)
+	if opts.DryRun {
+		return report, nil
+	}
+
+	if indexBroken {
+		if err := s.backend.DeleteMeta(indexName); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return report, err
+		}
+	}
+	if err := s.SaveList(list); err != nil {
+		return report, fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	keep := make(map[string]bool, len(list.Files))
+	for _, f := range list.Files {
+		keep[s.manifestName(f.Path)] = true
+	}
+	for _, name := range manifests {
+		if keep[name] {
+			continue
+		}
+		if err := s.backend.DeleteMeta(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return report, err
+		}
+	}
+	if err := s.backend.DeleteMeta(legacyListName); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return report, err
+	}
+	if err := writeFormat(s.backend, CurrentFormat); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// salvageLegacyList returns the files that can be read from list.json, if the
+// repository still has one. Snapshots of the flat layout are moved into the
+// content-addressed layout first so that their versions are not dropped.
+func (s *Store) salvageLegacyList(opts RepairOptions, report *RepairReport) ([]FileEntry, error) {
+	data, err := s.backend.LoadMeta(legacyListName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	var files []FileEntry
+	damaged := err != nil
+	if !damaged {
+		files, damaged = salvageList(data)
+	}
+	if damaged {
+		report.Damaged = append(report.Damaged, legacyListName)
+	}
+	if opts.DryRun {
+		return files, nil
+	}
+
+	// Replace a damaged list.json with what was salvaged, so that it can be
+	// read until the repaired manifests take over.
+	if damaged {
+		if err := saveLegacyList(s.backend, &List{Files: append([]FileEntry{}, files...)}); err != nil {
+			return nil, err
+		}
+	}
+
+	local, ok := s.backend.(*LocalBackend)
+	if !ok || !hasFlatSnapshots(local) {
+		return files, nil
+	}
+	if err := s.migrateSnapshots(); err != nil {
+		return nil, fmt.Errorf("failed to migrate snapshots: %w", err)
+	}
+	list, err := loadLegacyList(s.backend)
+	if err != nil {
+		return nil, err
+	}
+	return list.Files, nil
+}
+
+// dropBroken removes the versions whose snapshots are missing, as well as
+// deltas whose base is gone. Versions must be sorted newest first.
+func (s *Store) dropBroken(list *List, report *RepairReport) {
+	for i := range list.Files {
+		entry := &list.Files[i]
+		kept := entry.Versions[:0]
+		for _, v := range entry.Versions {
+			missing := ""
+			for _, key := range v.Objects() {
+				if key == "" || !s.Has(key) {
+					missing = key
+					break
+				}
+			}
+			if missing == "" && v.DeltaBase != "" {
+				missing = v.DeltaBase
+				for _, base := range kept {
+					if base.Hash == v.DeltaBase {
+						missing = ""
+					}
+				}
+			}
+			if missing != "" || (v.Hash == "" && len(v.Chunks) == 0) {
+				report.Dropped = append(report.Dropped, Problem{Kind: ProblemMissing, Path: entry.Path, Version: v.ID, Object: missing})
+				continue
+			}
+			kept = append(kept, v)
+		}
+		entry.Versions = kept
+	}
+
+	files := list.Files[:0]
+	for _, f := range list.Files {
+		if len(f.Versions) > 0 {
+			files = append(files, f)
+		}
+	}
+	list.Files = files
+}
+
+// sortVersions orders the versions of every file newest first, which delta
+// chains rely on.
+func sortVersions(list *List) {
+	for i := range list.Files {
+		versions := list.Files[i].Versions
+		sort.SliceStable(versions, func(a, b int) bool {
+			return versions[a].CreatedAt.After(versions[b].CreatedAt)
+		})
+	}
+}
+
+// recoverOrphans adds every stored snapshot no version references as a
+// version of opts.Path, after checking that its content matches its key.
+func (s *Store) recoverOrphans(list *List, opts RepairOptions, report *RepairReport) error {
+	reachable := make(map[string]bool)
+	for _, f := range list.Files {
+		for _, v := range f.Versions {
+			for _, key := range v.Objects() {
+				reachable[s.blobName(key)] = true
+			}
+		}
+	}
+
+	stored, err := s.backend.List()
+	if err != nil {
+		return fmt.Errorf("failed to list blobs: %w", err)
+	}
+	for _, name := range stored {
+		key, codec := splitCodec(name)
+		if reachable[key] || strings.Contains(key, "-") {
+			continue
+		}
+		reachable[key] = true
+
+		r, err := s.openListed(name)
+		if err != nil {
+			report.Unreadable = append(report.Unreadable, name)
+			continue
+		}
+		hash := sha256.New()
+		size, err := io.Copy(hash, r)
+		r.Close()
+		sum := hex.EncodeToString(hash.Sum(nil))
+		if err != nil || s.blobName(sum) != key {
+			report.Unreadable = append(report.Unreadable, name)
+			continue
+		}
+
+		storedSize, _ := s.backend.Stat(sum + codecExt(codec))
+		created := time.Now()
+		if local, ok := plainBackend(s.backend).(*LocalBackend); ok {
+			if info, err := os.Stat(local.Path(name)); err == nil {
+				created = info.ModTime()
+			}
+		}
+		list.AddVersion(opts.Path, Version{
+			ID:         list.NewVersionID(),
+			CreatedAt:  created,
+			Tags:       []string{"recovered"},
+			Notes:      "Recovered by repair from snapshot " + sum[:12],
+			Size:       size,
+			Hash:       sum,
+			StoredSize: storedSize,
+			Codec:      codec,
+		})
+		report.Recovered++
+	}
+	return nil
+}
+
+// salvageEntry decodes as much of a manifest as possible: every version up to
+// the point of damage, and the path if it can be read. It reports whether the
+// manifest was damaged.
+func salvageEntry(data []byte) (FileEntry, bool) {
+	var entry FileEntry
+	if err := json.Unmarshal(data, &entry); err == nil {
+		return entry, false
+	}
+
+	entry = FileEntry{}
+	decodeEntry(json.NewDecoder(bytes.NewReader(data)), &entry)
+	return entry, true
+}
+
+// salvageList decodes as much of list.json as possible, like salvageEntry.
+func salvageList(data []byte) ([]FileEntry, bool) {
+	var list List
+	if err := json.Unmarshal(data, &list); err == nil {
+		return list.Files, false
+	}
+
+	var files []FileEntry
+	dec := json.NewDecoder(bytes.NewReader(data))
+	decodeObject(dec, func(key string) error {
+		if key != "files" {
+			return skipValue(dec)
+		}
+		return decodeArray(dec, func() error {
+			var entry FileEntry
+			err := decodeEntry(dec, &entry)
+			if len(entry.Versions) > 0 {
+				files = append(files, entry)
+			}
+			return err
+		})
+	})
+	return files, true
+}
+
+func decodeEntry(dec *json.Decoder, entry *FileEntry) error {
+	return decodeObject(dec, func(key string) error {
+		switch key {
+		case "path":
+			return dec.Decode(&entry.Path)
+		case "versions":
+			return decodeArray(dec, func() error {
+				var v Version
+				if err := dec.Decode(&v); err != nil {
+					return err
+				}
+				entry.Versions = append(entry.Versions, v)
+				return nil
+			})
+		}
+		return skipValue(dec)
+	})
+}
+
+// decodeObject calls field for every key of the JSON object read from dec,
+// which must decode the value.
+func decodeObject(dec *json.Decoder, field func(key string) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		if err := field(key); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// decodeArray calls elem for every element of the JSON array read from dec,
+// which must decode the element.
+func decodeArray(dec *json.Decoder, elem func() error) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	for dec.More() {
+		if err := elem(); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected %s, got %v", delim, tok)
+	}
+	return nil
+}
+
+func skipValue(dec *json.Decoder) error {
+	var skip json.RawMessage
+	return dec.Decode(&skip)
+}
@@ -0,0 +1,191 @@
+package shadow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newRepairStore(t *testing.T) (*Store, *List, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	store := NewStore(NewLocalBackend(filepath.Join(tmpDir, ".shadow")))
+	src := filepath.Join(tmpDir, "a.txt")
+
+	list := &List{}
+	for i := 0; i < 3; i++ {
+		os.WriteFile(src, []byte(fmt.Sprintf("content %d", i)), 0644)
+		blob, err := store.Put(src)
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		list.AddVersion(src, Version{
+			ID:        fmt.Sprintf("v%d", i),
+			CreatedAt: time.Now().Add(time.Duration(i) * time.Minute),
+			Size:      blob.Size,
+			Hash:      blob.Hash,
+		})
+	}
+	if err := store.SaveList(list); err != nil {
+		t.Fatalf("SaveList failed: %v", err)
+	}
+	return store, list, src
+}
+
+func TestRepair_TruncatedManifest(t *testing.T) {
+	store, _, src := newRepairStore(t)
+
+	name := manifestName(src)
+	data, _ := store.Backend().LoadMeta(name)
+	store.Backend().StoreMeta(name, data[:len(data)*2/3])
+
+	report, err := store.Repair(RepairOptions{Path: "/recovered"})
+	if err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	if len(report.Damaged) != 1 || report.Damaged[0] != name {
+		t.Errorf("expected the manifest to be reported damaged, got %v", report.Damaged)
+	}
+	if report.Backup == "" {
+		t.Error("expected metadata to be backed up")
+	}
+
+	list, err := store.LoadList()
+	if err != nil {
+		t.Fatalf("LoadList failed after repair: %v", err)
+	}
+	salvaged := len(list.FindFile(src).Versions)
+	if salvaged == 0 {
+		t.Fatal("expected versions to be salvaged")
+	}
+	// Versions lost with the damage come back as orphans.
+	if recovered := list.FindFile("/recovered"); salvaged+len(recovered.Versions) != 3 {
+		t.Errorf("expected 3 versions in total, got %d salvaged and %d recovered", salvaged, len(recovered.Versions))
+	}
+
+	fsck, err := store.Fsck(2)
+	if err != nil || !fsck.OK() {
+		t.Errorf("repaired repository should pass fsck: %v (%v)", fsck.Problems, err)
+	}
+}
+
+func TestRepair_LostMetadata(t *testing.T) {
+	store, list, src := newRepairStore(t)
+	backend := store.Backend()
+	backend.StoreMeta(indexName, []byte("{not json"))
+	backend.DeleteMeta(manifestName(src))
+
+	report, err := store.Repair(RepairOptions{Path: "/recovered", DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if report.Recovered != 3 || report.Backup != "" {
+		t.Errorf("unexpected dry run report: %+v", report)
+	}
+	if _, err := store.LoadList(); err == nil {
+		t.Fatal("dry run should not change anything")
+	}
+
+	if _, err := store.Repair(RepairOptions{Path: "/recovered"}); err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	repaired, err := store.LoadList()
+	if err != nil {
+		t.Fatalf("LoadList failed after repair: %v", err)
+	}
+	entry := repaired.FindFile("/recovered")
+	if entry == nil || len(entry.Versions) != 3 {
+		t.Fatalf("expected 3 recovered versions, got %+v", repaired.Files)
+	}
+	for _, v := range list.FindFile(src).Versions {
+		found := false
+		for _, r := range entry.Versions {
+			if r.Hash == v.Hash && r.Size == v.Size {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("version %s was not recovered with its hash and size", v.ID)
+		}
+	}
+}
+
+func TestRepair_LegacyList(t *testing.T) {
+	store := NewStore(NewMemoryBackend())
+	store.putBytes("aaaa", []byte("x"))
+	store.Backend().StoreMeta(legacyListName, []byte(`{"files":[
+		{"path":"/etc/a.conf","versions":[{"id":"v1","hash":"aaaa","size":1},{"id":"v2","hash":"bb`))
+
+	report, err := store.Repair(RepairOptions{Path: "/recovered"})
+	if err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	if len(report.Damaged) != 1 || report.Damaged[0] != legacyListName {
+		t.Errorf("expected list.json to be reported damaged, got %v", report.Damaged)
+	}
+
+	list, err := store.LoadList()
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+	if entry := list.FindFile("/etc/a.conf"); entry == nil || len(entry.Versions) != 1 {
+		t.Errorf("expected the intact version to be salvaged, got %+v", list.Files)
+	}
+	if format, _ := store.Format(); format != CurrentFormat {
+		t.Errorf("expected format %d after repair, got %d", CurrentFormat, format)
+	}
+}
+
+func TestAutoBackup_Rotation(t *testing.T) {
+	store, _, _ := newRepairStore(t)
+
+	for i := 0; i < 4; i++ {
+		if err := store.AutoBackup(0, 2); err != nil {
+			t.Fatalf("AutoBackup failed: %v", err)
+		}
+	}
+	backups, _ := store.Backups()
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups after rotation, got %d", len(backups))
+	}
+	names, _ := store.Backend().ListMeta("backups/")
+	if len(names) != 2*len(backups[0].Documents) {
+		t.Errorf("rotated backups should be removed, found %v", names)
+	}
+
+	if err := store.AutoBackup(time.Hour, 2); err != nil {
+		t.Fatalf("AutoBackup failed: %v", err)
+	}
+	if again, _ := store.Backups(); len(again) != 2 || again[1].Name != backups[1].Name {
+		t.Error("no backup should be taken within the interval")
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	store, list, src := newRepairStore(t)
+	if err := store.AutoBackup(0, 5); err != nil {
+		t.Fatalf("AutoBackup failed: %v", err)
+	}
+	backups, _ := store.Backups()
+
+	list.RemoveVersion(src, "v2")
+	list.AddVersion("/etc/b.conf", Version{ID: "b1", Hash: list.FindFile(src).Versions[0].Hash})
+	store.SaveList(list)
+
+	if err := store.RestoreBackup(backups[0].Name); err != nil {
+		t.Fatalf("RestoreBackup failed: %v", err)
+	}
+	restored, err := store.LoadList()
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+	if len(restored.Files) != 1 || len(restored.FindFile(src).Versions) != 3 {
+		t.Errorf("expected the backed up metadata, got %+v", restored.Files)
+	}
+
+	if err := store.RestoreBackup("missing"); err == nil {
+		t.Error("expected error for unknown backup")
+	}
+}
@@ -0,0 +1,155 @@
+package shadow
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrCorrupt is returned when the content of a version does not match its
+// recorded hash.
+var ErrCorrupt = errors.New("snapshot is corrupt")
+
+// RestoreOptions controls Restore.
+type RestoreOptions struct {
+	// Meta restores the recorded mode, modification time and extended
+	// attributes; Owner also restores the owner, see ApplyMeta.
+	Meta  bool
+	Owner bool
+
+	// Force restores content that does not match the recorded hash.
+	Force bool
+}
+
+// RestoreVersion writes the content of the version with the given ID to dst,
+// without its metadata. See Restore.
+func (s *Store) RestoreVersion(entry *FileEntry, id, dst string) error {
+	return s.Restore(entry, id, dst, RestoreOptions{})
+}
+
+// Restore replaces dst with the version with the given ID, or with the
+// symlink it was saved from. The content is written to a temporary file next
+// to dst, checked against the recorded hash, synced and renamed over dst, so
+// that dst is never left half written. Content that fails the check is
+// refused with ErrCorrupt unless opts.Force is set. A symlink at dst is
+// replaced rather than written through. Unless opts.Meta is set and metadata
+// was recorded, the file keeps the permissions of the file it replaces.
+func (s *Store) Restore(entry *FileEntry, id, dst string, opts RestoreOptions) error {
+	i := entry.versionIndex(id)
+	if i < 0 {
+		return fmt.Errorf("version not found: %s", id)
+	}
+	v := entry.Versions[i]
+
+	dir := filepath.Dir(dst)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	info, err := os.Lstat(dst)
+	exists := err == nil
+	if exists && info.IsDir() {
+		return fmt.Errorf("cannot replace directory: %s", dst)
+	}
+
+	if v.Link != "" {
+		tmp := filepath.Join(dir, "."+filepath.Base(dst)+"."+randomSuffix()+".tmp")
+		if err := os.Symlink(v.Link, tmp); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, dst); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		syncDir(dir)
+		return nil
+	}
+
+	var r io.Reader
+	if v.DeltaBase != "" && len(v.Chunks) == 0 {
+		content, err := s.readVersion(entry, i)
+		// Without content there is nothing to salvage, even with Force.
+		if err != nil && !(opts.Force && errors.Is(err, ErrCorrupt) && content != nil) {
+			return err
+		}
+		r = bytes.NewReader(content)
+	} else {
+		rc, err := s.OpenVersion(entry, id)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		r = rc
+	}
+
+	perm := os.FileMode(0644)
+	if opts.Meta && v.Meta != nil {
+		perm = v.Meta.Mode & modeMask
+	} else if exists && info.Mode().IsRegular() {
+		perm = info.Mode() & modeMask
+	}
+
+	// CreateTemp creates the file readable by its owner only, so that the
+	// content is never more exposed than it was.
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	done := false
+	defer func() {
+		if !done {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(tmp, io.TeeReader(r, hash)); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); v.Hash != "" && got != v.Hash && !opts.Force {
+		return fmt.Errorf("%w: version %s has content hash %s, expected %s", ErrCorrupt, v.ID, got, v.Hash)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if opts.Meta && v.Meta != nil {
+		if err := ApplyMeta(tmp.Name(), v.Meta, opts.Owner); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	done = true
+	syncDir(dir)
+	return nil
+}
+
+// syncDir makes a rename in dir durable where the platform supports syncing
+// directories.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+func randomSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,179 @@
+package shadow
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newRestoreStore(t *testing.T) (*Store, *List, string, string) {
+	t.Helper()
+	store := NewStore(NewMemoryBackend())
+	list := &List{Files: []FileEntry{}}
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	id := saveContent(t, store, list, path, "saved content\n")
+	return store, list, path, id
+}
+
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestRestore(t *testing.T) {
+	store, list, path, id := newRestoreStore(t)
+	os.WriteFile(path, []byte("changed\n"), 0644)
+	os.Chmod(path, 0600)
+
+	if err := store.Restore(list.FindFile(path), id, path, RestoreOptions{}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "saved content\n" {
+		t.Errorf("unexpected content: %q", data)
+	}
+	// Without recorded metadata the replaced file's mode is kept.
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+	if tmp := tempFiles(t, filepath.Dir(path)); len(tmp) > 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+}
+
+func TestRestore_ModeWithoutMeta(t *testing.T) {
+	store := NewStore(NewMemoryBackend())
+	list := &List{Files: []FileEntry{}}
+	path := filepath.Join(t.TempDir(), "secret.txt")
+	os.WriteFile(path, []byte("saved content\n"), 0600)
+	blob, err := store.Put(path)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	meta, _ := ReadMeta(path, false)
+	list.AddVersion(path, Version{ID: "v1", Size: blob.Size, Hash: blob.Hash, Meta: meta})
+	os.Chmod(path, 0644)
+
+	// The recorded mode is metadata; without Meta the current mode is kept.
+	if err := store.Restore(list.FindFile(path), "v1", path, RestoreOptions{Meta: false}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644, got %v", info.Mode().Perm())
+	}
+
+	if err := store.Restore(list.FindFile(path), "v1", path, RestoreOptions{Meta: true}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("expected recorded mode 0600 with Meta, got %v", info.Mode().Perm())
+	}
+}
+
+func TestRestore_Corrupt(t *testing.T) {
+	store, list, path, id := newRestoreStore(t)
+	v := list.FindFile(path).Versions[0]
+	store.Backend().Put(v.Object(), strings.NewReader("garbage\n"))
+	os.WriteFile(path, []byte("current\n"), 0644)
+
+	err := store.Restore(list.FindFile(path), id, path, RestoreOptions{})
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "current\n" {
+		t.Errorf("expected target untouched, got %q", data)
+	}
+	if tmp := tempFiles(t, filepath.Dir(path)); len(tmp) > 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+
+	if err := store.Restore(list.FindFile(path), id, path, RestoreOptions{Force: true}); err != nil {
+		t.Fatalf("forced Restore failed: %v", err)
+	}
+	data, _ = os.ReadFile(path)
+	if string(data) != "garbage\n" {
+		t.Errorf("expected forced content, got %q", data)
+	}
+}
+
+func TestRestore_CorruptDelta(t *testing.T) {
+	store, list, path := newDeltaStore(t)
+	older := saveContent(t, store, list, path, dumpContent(1))
+	saveContent(t, store, list, path, dumpContent(2))
+
+	entry := list.FindFile(path)
+	if entry.Versions[1].DeltaBase == "" {
+		t.Fatal("expected older version to be stored as a delta")
+	}
+	// Swap the base for other content so the delta applies to the wrong bytes.
+	store.Backend().Put(entry.Versions[0].Object(), strings.NewReader(dumpContent(3)))
+
+	err := store.Restore(entry, older, path, RestoreOptions{})
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != dumpContent(2) {
+		t.Error("expected target untouched")
+	}
+}
+
+func TestRestore_ForceCorruptDeltaChain(t *testing.T) {
+	store, list, path := newDeltaStore(t)
+	oldest := saveContent(t, store, list, path, dumpContent(1))
+	saveContent(t, store, list, path, dumpContent(2))
+	saveContent(t, store, list, path, dumpContent(3))
+
+	entry := list.FindFile(path)
+	if entry.Versions[2].DeltaBase == "" || entry.Versions[1].DeltaBase == "" {
+		t.Fatal("expected a delta chain two steps deep")
+	}
+	store.Backend().Put(entry.Versions[0].Object(), strings.NewReader(dumpContent(4)))
+
+	err := store.Restore(entry, oldest, path, RestoreOptions{})
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+
+	if err := store.Restore(entry, oldest, path, RestoreOptions{Force: true}); err != nil {
+		t.Fatalf("forced Restore failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if len(data) == 0 {
+		t.Fatal("expected salvaged content, got an empty file")
+	}
+	// Only the rows the swapped base changed differ.
+	if !strings.Contains(string(data), "'changed in 1'") {
+		t.Error("expected the oldest version's own change in the salvaged content")
+	}
+}
+
+func TestRestore_ReplacesSymlink(t *testing.T) {
+	store, list, path, id := newRestoreStore(t)
+	dir := filepath.Dir(path)
+	other := filepath.Join(dir, "other.txt")
+	os.WriteFile(other, []byte("other\n"), 0644)
+	os.Remove(path)
+	os.Symlink(other, path)
+
+	if err := store.Restore(list.FindFile(path), id, path, RestoreOptions{}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	if info, _ := os.Lstat(path); info.Mode()&os.ModeSymlink != 0 {
+		t.Error("expected symlink to be replaced")
+	}
+	data, _ := os.ReadFile(other)
+	if string(data) != "other\n" {
+		t.Errorf("expected link target untouched, got %q", data)
+	}
+}
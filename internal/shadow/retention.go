@@ -0,0 +1,234 @@
+package shadow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retention decides which versions of a file to keep. A version is kept if
+// any keep rule selects it, or if no keep rule is set. MaxAge and MaxSize
+// then remove the oldest versions left. Versions carrying a protected tag and
+// the newest version of a file are never removed.
+type Retention struct {
+	// KeepLast keeps the newest versions.
+	KeepLast int
+
+	// KeepDaily, KeepWeekly and KeepMonthly keep the newest version of every
+	// day, week and month within that many days, weeks and months.
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+
+	// MaxAge removes versions older than it.
+	MaxAge time.Duration
+
+	// MaxSize bounds the total size of the versions of a file.
+	MaxSize int64
+
+	// Thin keeps versions the way Time Machine does: every version of the
+	// last hour, the newest of every hour for a day, of every day for a
+	// month and of every week before that.
+	Thin bool
+
+	// Protected lists the tags of versions that are never removed.
+	Protected []string
+}
+
+// Enabled reports whether the policy can remove anything.
+func (r Retention) Enabled() bool {
+	return r.keeps() || r.MaxAge > 0 || r.MaxSize > 0
+}
+
+func (r Retention) keeps() bool {
+	return r.KeepLast > 0 || r.KeepDaily > 0 || r.KeepWeekly > 0 || r.KeepMonthly > 0 || r.Thin
+}
+
+func (r Retention) protected(v Version) bool {
+	for _, tag := range v.Tags {
+		for _, p := range r.Protected {
+			if tag == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Expired returns the IDs of the versions of entry the policy removes at
+// time now. Versions are expected newest first, as kept in the list.
+func (r Retention) Expired(entry *FileEntry, now time.Time) []string {
+	versions := entry.Versions
+	if len(versions) == 0 || !r.Enabled() {
+		return nil
+	}
+
+	keep := make([]bool, len(versions))
+	if !r.keeps() {
+		for i := range keep {
+			keep[i] = true
+		}
+	}
+	for i := 0; i < r.KeepLast && i < len(versions); i++ {
+		keep[i] = true
+	}
+
+	buckets := []struct {
+		n     int
+		since time.Time
+		key   func(time.Time) string
+	}{
+		{r.KeepDaily, now.AddDate(0, 0, -r.KeepDaily), func(t time.Time) string { return t.Format("2006-01-02") }},
+		{r.KeepWeekly, now.AddDate(0, 0, -7*r.KeepWeekly), func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{r.KeepMonthly, now.AddDate(0, -r.KeepMonthly, 0), func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, b := range buckets {
+		if b.n <= 0 {
+			continue
+		}
+		seen := make(map[string]bool)
+		for i, v := range versions {
+			t := v.CreatedAt.In(now.Location())
+			if t.Before(b.since) {
+				continue
+			}
+			if key := b.key(t); !seen[key] {
+				seen[key] = true
+				keep[i] = true
+			}
+		}
+	}
+
+	if r.Thin {
+		seen := make(map[string]bool)
+		for i, v := range versions {
+			key := thinBucket(v.CreatedAt.In(now.Location()), now)
+			if key == "" || !seen[key] {
+				seen[key] = true
+				keep[i] = true
+			}
+		}
+	}
+
+	if r.MaxAge > 0 {
+		for i, v := range versions {
+			if now.Sub(v.CreatedAt) > r.MaxAge {
+				keep[i] = false
+			}
+		}
+	}
+
+	if r.MaxSize > 0 {
+		var total int64
+		for i, v := range versions {
+			if keep[i] || r.protected(v) || i == 0 {
+				total += v.Size
+			}
+		}
+		for i := len(versions) - 1; i > 0 && total > r.MaxSize; i-- {
+			if keep[i] && !r.protected(versions[i]) {
+				keep[i] = false
+				total -= versions[i].Size
+			}
+		}
+	}
+
+	var expired []string
+	for i, v := range versions {
+		if !keep[i] && i > 0 && !r.protected(v) {
+			expired = append(expired, v.ID)
+		}
+	}
+	return expired
+}
+
+// thinBucket returns the period of the thinning schedule t falls in at time
+// now, of which only the newest version is kept. Versions of the last hour
+// are all kept and get no bucket.
+func thinBucket(t, now time.Time) string {
+	switch age := now.Sub(t); {
+	case age < time.Hour:
+		return ""
+	case age < 24*time.Hour:
+		return t.Format("h2006-01-02T15")
+	case t.After(now.AddDate(0, -1, 0)):
+		return t.Format("d2006-01-02")
+	}
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("w%d-W%02d", year, week)
+}
+
+// Prune removes the versions of path that the policy expires from list and
+// returns them, along with blob keys to release after saving the list. With
+// dryRun it only returns them; neither list nor the repository is changed,
+// as removing a version may store dependent deltas anew.
+func (s *Store) Prune(list *List, path string, policy Retention, now time.Time, dryRun bool) ([]Version, []string, error) {
+	entry := list.FindFile(path)
+	if entry == nil {
+		return nil, nil, nil
+	}
+
+	var removed []Version
+	var garbage []string
+	for _, id := range policy.Expired(entry, now) {
+		i := entry.versionIndex(id)
+		if i < 0 {
+			continue
+		}
+		v := entry.Versions[i]
+		if dryRun {
+			removed = append(removed, v)
+			continue
+		}
+		keys, err := s.Unlink(list, path, id)
+		if err != nil {
+			return removed, garbage, err
+		}
+		removed = append(removed, v)
+		garbage = append(garbage, keys...)
+
+		// Unlink drops the entry along with its last version.
+		if entry = list.FindFile(path); entry == nil {
+			break
+		}
+	}
+	return removed, garbage, nil
+}
+
+// ParseSize parses a size as found in the config file, such as "500MB" or
+// "2GiB". Units are powers of 1024, as elsewhere in shadow.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+		{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+		{"B", 1},
+	}
+	upper := strings.ToUpper(s)
+	factor := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.suffix) {
+			factor = u.factor
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, u.suffix))
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return int64(n * float64(factor)), nil
+}
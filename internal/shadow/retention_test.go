@@ -0,0 +1,198 @@
+package shadow
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// retentionEntry returns an entry with a version of size 10 every day for
+// days days, newest first, IDs counting the days back from now.
+func retentionEntry(now time.Time, days int) *FileEntry {
+	entry := &FileEntry{Path: "/tmp/a.txt"}
+	for i := 0; i < days; i++ {
+		entry.Versions = append(entry.Versions, Version{
+			ID:        string(rune('a' + i)),
+			CreatedAt: now.AddDate(0, 0, -i),
+			Size:      10,
+		})
+	}
+	return entry
+}
+
+func TestRetention_Expired(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		policy Retention
+		want   []string
+	}{
+		{"disabled", Retention{}, nil},
+		{"keep last", Retention{KeepLast: 3}, []string{"d", "e", "f", "g", "h", "i", "j"}},
+		{"keep daily", Retention{KeepDaily: 2}, []string{"d", "e", "f", "g", "h", "i", "j"}},
+		{"keep weekly", Retention{KeepWeekly: 2}, []string{"b", "c", "d", "e", "g", "h", "i", "j"}},
+		{"max age", Retention{MaxAge: 72 * time.Hour}, []string{"e", "f", "g", "h", "i", "j"}},
+		{"max size", Retention{MaxSize: 25}, []string{"c", "d", "e", "f", "g", "h", "i", "j"}},
+		{"max age overrides keep", Retention{KeepLast: 10, MaxAge: 24 * time.Hour}, []string{"c", "d", "e", "f", "g", "h", "i", "j"}},
+		{"newest always kept", Retention{MaxAge: time.Hour}, []string{"b", "c", "d", "e", "f", "g", "h", "i", "j"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.Expired(retentionEntry(now, 10), now)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRetention_Protected(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	entry := retentionEntry(now, 4)
+	entry.Versions[3].Tags = []string{"release"}
+
+	policy := Retention{KeepLast: 1, MaxSize: 10, Protected: []string{"release"}}
+	got := policy.Expired(entry, now)
+	if want := []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(NewLocalBackend(filepath.Join(tmpDir, ".shadow")))
+	store.Mode = ModeDelta
+	store.MaxDeltaChain = 10
+
+	path := filepath.Join(tmpDir, "a.txt")
+	list := &List{}
+	var ids []string
+	for _, content := range []string{"one", "one two", "one two three"} {
+		ids = append(ids, saveContent(t, store, list, path, content))
+	}
+
+	removed, garbage, err := store.Prune(list, path, Retention{KeepLast: 1}, time.Now(), false)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("expected 2 versions removed, got %d", len(removed))
+	}
+	if err := store.SaveList(list); err != nil {
+		t.Fatalf("SaveList failed: %v", err)
+	}
+	if _, _, err := store.Release(list, garbage...); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	entry := list.FindFile(path)
+	if len(entry.Versions) != 1 || entry.Versions[0].ID != ids[2] {
+		t.Fatalf("expected only the newest version left, got %+v", entry.Versions)
+	}
+	if got := readContent(t, store, list, path, ids[2]); got != "one two three" {
+		t.Errorf("unexpected content: %q", got)
+	}
+
+	report, err := store.GC(GCOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if len(report.Removed) != 0 {
+		t.Errorf("pruned snapshots should be released, found %+v", report.Removed)
+	}
+}
+
+func TestStore_PruneDryRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(NewLocalBackend(filepath.Join(tmpDir, ".shadow")))
+	store.Mode = ModeDelta
+	store.MaxDeltaChain = 10
+
+	path := filepath.Join(tmpDir, "a.txt")
+	list := &List{}
+	var ids []string
+	for i := 1; i <= 3; i++ {
+		ids = append(ids, saveContent(t, store, list, path, dumpContent(i)))
+	}
+	// The oldest version survives and depends on the middle one, so really
+	// pruning would store it as a delta against the newest.
+	list.FindFile(path).Versions[2].Tags = []string{"protected"}
+
+	before, err := store.Backend().List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	policy := Retention{KeepLast: 1, Protected: []string{"protected"}}
+	removed, garbage, err := store.Prune(list, path, policy, time.Now(), true)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(removed) != 1 || removed[0].ID != ids[1] || len(garbage) != 0 {
+		t.Fatalf("expected only the middle version reported, got %+v, %v", removed, garbage)
+	}
+	if n := len(list.FindFile(path).Versions); n != 3 {
+		t.Errorf("expected list untouched, got %d versions", n)
+	}
+
+	after, err := store.Backend().List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("dry run changed the blobs: %v -> %v", before, after)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"":       0,
+		"512":    512,
+		"10B":    10,
+		"1k":     1 << 10,
+		"2MB":    2 << 20,
+		"1.5GiB": 3 << 29,
+	}
+	for in, want := range tests {
+		got, err := ParseSize(in)
+		if err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; expected %d", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"abc", "-1MB", "MB"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) should fail", in)
+		}
+	}
+}
+
+func TestRetention_Thin(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	entry := &FileEntry{Path: "/tmp/a.txt"}
+	add := func(id string, age time.Duration) {
+		entry.Versions = append(entry.Versions, Version{ID: id, CreatedAt: now.Add(-age)})
+	}
+	// Every version of the last hour is kept.
+	add("m10", 10*time.Minute)
+	add("m50", 50*time.Minute)
+	// Hourly for a day: the 8 o'clock hour has two.
+	add("h3a", 3*time.Hour+10*time.Minute)
+	add("h3b", 3*time.Hour+40*time.Minute)
+	add("h5", 5*time.Hour)
+	// Daily for a month: the 12th has two.
+	add("d3a", 72*time.Hour)
+	add("d3b", 80*time.Hour)
+	add("d10", 240*time.Hour)
+	// Weekly after that: 2024-W05 has two.
+	add("w1", 40*24*time.Hour)
+	add("w2", 41*24*time.Hour)
+	add("w3", 50*24*time.Hour)
+
+	got := Retention{Thin: true}.Expired(entry, now)
+	if want := []string{"h3b", "d3b", "w2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
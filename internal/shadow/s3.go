@@ -0,0 +1,466 @@
+package shadow
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Options configures an S3Backend.
+type S3Options struct {
+	// Endpoint is the base URL of an S3-compatible service such as MinIO.
+	// Buckets are then addressed path-style. When empty, AWS is used with
+	// virtual-hosted addressing.
+	Endpoint string
+	Region   string
+	Bucket   string
+	Prefix   string
+
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	Client *http.Client
+}
+
+// S3Backend keeps blobs and metadata in an S3 bucket using the same layout
+// as a local .shadow directory below Prefix. Metadata documents are replaced
+// with conditional writes so concurrent updates fail with ErrConflict instead
+// of silently overwriting each other.
+type S3Backend struct {
+	opts S3Options
+
+	mu    sync.Mutex
+	etags map[string]string
+}
+
+// ParseS3URL splits an s3://bucket/prefix URL into bucket and prefix.
+func ParseS3URL(raw string) (string, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid S3 URL: %s", raw)
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// NewS3Backend returns a backend for the bucket and prefix in opts.
+func NewS3Backend(opts S3Options) *S3Backend {
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+	opts.Prefix = strings.Trim(opts.Prefix, "/")
+	return &S3Backend{opts: opts, etags: make(map[string]string)}
+}
+
+func (b *S3Backend) objectKey(name string) string {
+	if b.opts.Prefix == "" {
+		return name
+	}
+	return b.opts.Prefix + "/" + name
+}
+
+func (b *S3Backend) blobKey(key string) string {
+	if len(key) < 3 {
+		return b.objectKey("snapshots/" + key)
+	}
+	return b.objectKey("snapshots/" + key[:2] + "/" + key[2:])
+}
+
+func (b *S3Backend) bucketURL() string {
+	if b.opts.Endpoint != "" {
+		return b.opts.Endpoint + "/" + b.opts.Bucket
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com", b.opts.Bucket, b.opts.Region)
+}
+
+func (b *S3Backend) newRequest(method, objectKey string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := b.bucketURL() + "/" + s3EscapePath(objectKey)
+	if len(query) > 0 {
+		u += "?" + s3CanonicalQuery(query)
+	}
+	return http.NewRequest(method, u, body)
+}
+
+func (b *S3Backend) do(req *http.Request) (*http.Response, error) {
+	signS3Request(req, b.opts, time.Now().UTC())
+
+	resp, err := b.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			return nil, notExist(strings.ToLower(req.Method), req.URL.Path)
+		case http.StatusPreconditionFailed, http.StatusConflict:
+			return nil, ErrConflict
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (b *S3Backend) Put(key string, r io.Reader) error {
+	// S3 needs the content length up front, so spool the blob first.
+	tmp, err := os.CreateTemp("", "shadow-s3-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := b.newRequest(http.MethodPut, b.blobKey(key), nil, tmp)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	resp, err := b.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *S3Backend) Get(key string) (io.ReadCloser, error) {
+	req, err := b.newRequest(http.MethodGet, b.blobKey(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *S3Backend) Stat(key string) (int64, error) {
+	req, err := b.newRequest(http.MethodHead, b.blobKey(key), nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+func (b *S3Backend) Delete(key string) error {
+	if _, err := b.Stat(key); err != nil {
+		return err
+	}
+
+	req, err := b.newRequest(http.MethodDelete, b.blobKey(key), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (b *S3Backend) List() ([]string, error) {
+	prefix := b.objectKey("snapshots/")
+	objects, err := b.listObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(objects))
+	for _, object := range objects {
+		keys = append(keys, strings.ReplaceAll(strings.TrimPrefix(object, prefix), "/", ""))
+	}
+	return keys, nil
+}
+
+func (b *S3Backend) ListMeta(prefix string) ([]string, error) {
+	objects, err := b.listObjects(b.objectKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(objects))
+	for _, object := range objects {
+		names = append(names, strings.TrimPrefix(object, b.objectKey("")))
+	}
+	return names, nil
+}
+
+// listObjects returns the keys of all objects in the bucket starting with
+// prefix.
+func (b *S3Backend) listObjects(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := b.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := b.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (b *S3Backend) LoadMeta(name string) ([]byte, error) {
+	req, err := b.newRequest(http.MethodGet, b.objectKey(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			b.mu.Lock()
+			b.etags[name] = ""
+			b.mu.Unlock()
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	b.etags[name] = resp.Header.Get("ETag")
+	b.mu.Unlock()
+	return data, nil
+}
+
+// StoreMeta replaces the metadata document only if it is unchanged since it
+// was last loaded through this backend, and fails with ErrConflict otherwise.
+func (b *S3Backend) StoreMeta(name string, data []byte) error {
+	req, err := b.newRequest(http.MethodPut, b.objectKey(name), nil, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+
+	b.mu.Lock()
+	etag, loaded := b.etags[name]
+	b.mu.Unlock()
+	if loaded {
+		if etag == "" {
+			req.Header.Set("If-None-Match", "*")
+		} else {
+			req.Header.Set("If-Match", etag)
+		}
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	b.mu.Lock()
+	b.etags[name] = resp.Header.Get("ETag")
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *S3Backend) DeleteMeta(name string) error {
+	req, err := b.newRequest(http.MethodHead, b.objectKey(name), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	req, err = b.newRequest(http.MethodDelete, b.objectKey(name), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err = b.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	b.mu.Lock()
+	b.etags[name] = ""
+	b.mu.Unlock()
+	return nil
+}
+
+// signS3Request adds AWS Signature Version 4 headers to req. The payload is
+// left unsigned so blobs can be streamed.
+func signS3Request(req *http.Request, opts S3Options, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	if opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", opts.SessionToken)
+	}
+	if opts.AccessKey == "" {
+		return
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "if-match" || lower == "if-none-match" {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := date + "/" + opts.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+opts.SecretKey), date)
+	key = hmacSHA256(key, opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		opts.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape percent-encodes s the way SigV4 expects: everything except
+// unreserved characters.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		parts[i] = s3Escape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,211 @@
+package shadow
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a minimal in-memory S3 server supporting the requests made by
+// S3Backend, including conditional writes.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3(t *testing.T) *httptest.Server {
+	f := &fakeS3{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func fakeETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test/") {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Path-style addressing: /bucket/key
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	key := ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+
+	if key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+		f.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	data, exists := f.objects[key]
+	switch r.Method {
+	case http.MethodPut:
+		if match := r.Header.Get("If-Match"); match != "" && (!exists || match != fakeETag(data)) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+		w.Header().Set("ETag", fakeETag(body))
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", fakeETag(data))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key string `xml:"Key"`
+	}
+	var result struct {
+		XMLName  xml.Name  `xml:"ListBucketResult"`
+		Contents []content `xml:"Contents"`
+	}
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result.Contents = append(result.Contents, content{Key: key})
+	}
+
+	xml.NewEncoder(w).Encode(result)
+}
+
+func newTestS3Backend(srv *httptest.Server) *S3Backend {
+	return NewS3Backend(S3Options{
+		Endpoint:  srv.URL,
+		Bucket:    "shadow",
+		Prefix:    "team/.shadow",
+		AccessKey: "test",
+		SecretKey: "secret",
+	})
+}
+
+func TestS3Backend(t *testing.T) {
+	srv := newFakeS3(t)
+	testBackend(t, newTestS3Backend(srv))
+}
+
+func TestS3Backend_ConditionalMeta(t *testing.T) {
+	srv := newFakeS3(t)
+	first := newTestS3Backend(srv)
+	second := newTestS3Backend(srv)
+
+	first.LoadMeta("list.json")
+	second.LoadMeta("list.json")
+
+	if err := first.StoreMeta("list.json", []byte("first")); err != nil {
+		t.Fatalf("StoreMeta failed: %v", err)
+	}
+	if err := second.StoreMeta("list.json", []byte("second")); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict creating existing metadata, got %v", err)
+	}
+
+	if _, err := second.LoadMeta("list.json"); err != nil {
+		t.Fatalf("LoadMeta failed: %v", err)
+	}
+	if err := first.StoreMeta("list.json", []byte("first again")); err != nil {
+		t.Fatalf("StoreMeta failed: %v", err)
+	}
+	if err := second.StoreMeta("list.json", []byte("second")); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict on stale update, got %v", err)
+	}
+
+	data, _ := first.LoadMeta("list.json")
+	if string(data) != "first again" {
+		t.Errorf("conflicting write should not overwrite metadata, got %q", string(data))
+	}
+}
+
+func TestS3Backend_Store(t *testing.T) {
+	srv := newFakeS3(t)
+	store := NewStore(newTestS3Backend(srv))
+	store.Mode = ModeChunked
+
+	list, err := store.LoadList()
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+
+	content := strings.Repeat("remote snapshot\n", 2000)
+	src := filepath.Join(t.TempDir(), "app.conf")
+	os.WriteFile(src, []byte(content), 0644)
+	blob, err := store.Put(src)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	list.AddVersion(src, Version{ID: "v1", Hash: blob.Hash, Size: blob.Size, Chunks: blob.Chunks})
+	if err := store.SaveList(list); err != nil {
+		t.Fatalf("SaveList failed: %v", err)
+	}
+
+	reopened := NewStore(newTestS3Backend(srv))
+	loaded, err := reopened.LoadList()
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+	r, err := reopened.OpenVersion(loaded.FindFile(src), "v1")
+	if err != nil {
+		t.Fatalf("OpenVersion failed: %v", err)
+	}
+	defer r.Close()
+	restored, _ := io.ReadAll(r)
+	if string(restored) != content {
+		t.Error("content read back from S3 does not match")
+	}
+}
+
+func TestParseS3URL(t *testing.T) {
+	bucket, prefix, err := ParseS3URL("s3://my-bucket/team/configs/")
+	if err != nil {
+		t.Fatalf("ParseS3URL failed: %v", err)
+	}
+	if bucket != "my-bucket" || prefix != "team/configs" {
+		t.Errorf("unexpected bucket %q prefix %q", bucket, prefix)
+	}
+
+	if _, _, err := ParseS3URL("/local/path"); err == nil {
+		t.Error("expected error for non-S3 path")
+	}
+}
@@ -0,0 +1,442 @@
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// Store is the content-addressed snapshot store of a repository. Blobs are
+// keyed by the full SHA-256 of their content and kept in a Backend.
+type Store struct {
+	backend Backend
+
+	// Codec is used to compress newly written blobs.
+	Codec string
+
+	// Mode selects how older versions are kept, see ParseMode.
+	Mode string
+
+	// MaxDeltaChain bounds how many deltas are applied to reconstruct a
+	// version in delta mode.
+	MaxDeltaChain int
+
+	unlock func() error
+}
+
+// Blob describes a snapshot held by the store.
+type Blob struct {
+	Hash       string
+	Size       int64
+	StoredSize int64
+	Codec      string
+	Chunks     []Chunk
+}
+
+// NewStore returns the store keeping its data in backend.
+func NewStore(backend Backend) *Store {
+	return &Store{backend: backend, Mode: ModeFull, MaxDeltaChain: DefaultMaxDeltaChain}
+}
+
+// Backend returns the backend the store keeps its data in.
+func (s *Store) Backend() Backend {
+	return s.backend
+}
+
+// Lock takes an advisory lock on the repository if the backend supports it.
+// Modifying the repository needs an exclusive lock; a shared lock gives
+// readers a consistent view. The lock is released by Close.
+func (s *Store) Lock(exclusive bool, timeout time.Duration) error {
+	locker, ok := s.backend.(Locker)
+	if !ok || s.unlock != nil {
+		return nil
+	}
+
+	unlock, err := locker.Lock(exclusive, timeout)
+	if err != nil {
+		return err
+	}
+	s.unlock = unlock
+	return nil
+}
+
+// Close releases the repository lock, if any.
+func (s *Store) Close() error {
+	if s.unlock == nil {
+		return nil
+	}
+	err := s.unlock()
+	s.unlock = nil
+	return err
+}
+
+// locate finds the blob with the given key under any codec and returns its
+// codec and stored size.
+func (s *Store) locate(key string) (string, int64, bool) {
+	for _, codec := range codecs {
+		if size, err := s.backend.Stat(key + codecExt(codec)); err == nil {
+			return codec, size, true
+		}
+	}
+	return "", 0, false
+}
+
+// blobName returns the name the backend lists the blob stored as name under.
+func (s *Store) blobName(name string) string {
+	if b, ok := s.backend.(*EncryptedBackend); ok {
+		return b.blobName(name)
+	}
+	return name
+}
+
+// Has reports whether a blob with the given key exists.
+func (s *Store) Has(key string) bool {
+	_, _, ok := s.locate(key)
+	return ok
+}
+
+// Put stores the content of src compressed with the store codec. Content that
+// is already present, possibly under another codec, is not kept twice. In
+// chunked mode only chunks not yet present anywhere in the store are written.
+func (s *Store) Put(src string) (Blob, error) {
+	return s.PutContext(context.Background(), src, nil)
+}
+
+// PutContext is Put reading src only once: the content is hashed while it is
+// staged, then moved under its key, which depending on the backend copies it
+// again, see stage. If ctx is cancelled nothing is stored,
+// apart from complete chunks in chunked mode, which GC collects. progress, if
+// not nil, is called with the number of bytes of src read so far.
+func (s *Store) PutContext(ctx context.Context, src string, progress func(int64)) (Blob, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return Blob{}, err
+	}
+	defer file.Close()
+
+	r := &contextReader{ctx: ctx, r: file, progress: progress}
+	if s.Mode == ModeChunked {
+		return s.putChunked(r)
+	}
+
+	hash := sha256.New()
+	pr := s.compress(io.TeeReader(r, hash))
+	counter := &countingReader{r: pr}
+	commit, abort, err := s.stage(counter)
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		return Blob{}, err
+	}
+
+	key := hex.EncodeToString(hash.Sum(nil))
+	if codec, stored, ok := s.locate(key); ok {
+		if err := abort(); err != nil {
+			return Blob{}, err
+		}
+		return Blob{Hash: key, Size: r.n, StoredSize: stored, Codec: codec}, nil
+	}
+
+	if err := commit(key + codecExt(s.Codec)); err != nil {
+		abort()
+		return Blob{}, err
+	}
+	return Blob{Hash: key, Size: r.n, StoredSize: counter.n, Codec: s.Codec}, nil
+}
+
+// stage stores r as a pending blob, see Stager. Only plain local
+// repositories move it without copying it: encrypted ones seal it again under
+// its key, and for backends without Stager it is spooled and stored from
+// there, which S3 spools once more to learn its length.
+func (s *Store) stage(r io.Reader) (func(string) error, func() error, error) {
+	if stager, ok := s.backend.(Stager); ok {
+		return stager.Stage(r)
+	}
+
+	tmp, err := os.CreateTemp("", "shadow-*.tmp")
+	if err != nil {
+		return nil, nil, err
+	}
+	abort := func() error {
+		tmp.Close()
+		if err := os.Remove(tmp.Name()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		abort()
+		return nil, nil, err
+	}
+
+	commit := func(key string) error {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := s.backend.Put(key, tmp); err != nil {
+			return err
+		}
+		return abort()
+	}
+	return commit, abort, nil
+}
+
+// contextReader reads from r until ctx is cancelled, reporting progress.
+type contextReader struct {
+	ctx      context.Context
+	r        io.Reader
+	progress func(int64)
+	n        int64
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.progress != nil && n > 0 {
+		c.progress(c.n)
+	}
+	return n, err
+}
+
+// PutLink stores the target of the symlink at src. The snapshot of a link is
+// its target, so links are checked and collected like any other version.
+func (s *Store) PutLink(src string) (Blob, string, error) {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return Blob{}, "", err
+	}
+
+	sum := sha256.Sum256([]byte(target))
+	hash := hex.EncodeToString(sum[:])
+	blob, err := s.putBytes(hash, []byte(target))
+	if err != nil {
+		return Blob{}, "", err
+	}
+	blob.Hash = hash
+	return blob, target, nil
+}
+
+// putBytes stores data under key unless a blob with that key already exists.
+func (s *Store) putBytes(key string, data []byte) (Blob, error) {
+	if codec, stored, ok := s.locate(key); ok {
+		return Blob{Size: int64(len(data)), StoredSize: stored, Codec: codec}, nil
+	}
+
+	stored, err := s.write(key, bytes.NewReader(data))
+	if err != nil {
+		return Blob{}, err
+	}
+	return Blob{Size: int64(len(data)), StoredSize: stored, Codec: s.Codec}, nil
+}
+
+// write compresses r into the blob with the given key and returns the number
+// of bytes stored.
+func (s *Store) write(key string, r io.Reader) (int64, error) {
+	pr := s.compress(r)
+	counter := &countingReader{r: pr}
+	err := s.backend.Put(key+codecExt(s.Codec), counter)
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+// compress returns a reader yielding r compressed with the store codec. The
+// caller must close it once done reading.
+func (s *Store) compress(r io.Reader) *io.PipeReader {
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := compressWriter(s.Codec, pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+	return pr
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Open returns the decompressed content of the blob with the given key.
+func (s *Store) Open(key string) (io.ReadCloser, error) {
+	codec, _, ok := s.locate(key)
+	if !ok {
+		return nil, notExist("open", key)
+	}
+
+	raw, err := s.backend.Get(key + codecExt(codec))
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := decompressReader(codec, raw)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	return &blobReader{ReadCloser: r, raw: raw}, nil
+}
+
+// openListed opens the blob the backend lists under name. The key of a blob
+// of an encrypted repository cannot be told from its name.
+func (s *Store) openListed(name string) (io.ReadCloser, error) {
+	_, codec := splitCodec(name)
+	var raw io.ReadCloser
+	var err error
+	if b, ok := s.backend.(*EncryptedBackend); ok {
+		if raw, err = b.inner.Get(name); err == nil {
+			raw, err = b.open(name, raw)
+		}
+	} else {
+		raw, err = s.backend.Get(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := decompressReader(codec, raw)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return &blobReader{ReadCloser: r, raw: raw}, nil
+}
+
+func (s *Store) readAll(key string) ([]byte, error) {
+	r, err := s.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Remove deletes the blob with the given key under every codec. A missing
+// blob is not an error.
+func (s *Store) Remove(key string) error {
+	for _, codec := range codecs {
+		if err := s.backend.Delete(key + codecExt(codec)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+type blobReader struct {
+	io.ReadCloser
+	raw io.Closer
+}
+
+func (b *blobReader) Close() error {
+	err := b.ReadCloser.Close()
+	if rerr := b.raw.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// Release removes the blobs with the given keys that no version in list
+// references anymore. It returns how many blobs were removed and the bytes
+// they occupied. Callers must save list before releasing so that a crash
+// never leaves versions pointing at removed blobs.
+func (s *Store) Release(list *List, keys ...string) (int, int64, error) {
+	var candidates []string
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key != "" && !seen[key] && list.RefCount(key) == 0 && s.Has(key) {
+			seen[key] = true
+			candidates = append(candidates, key)
+		}
+	}
+
+	// Other paths may share the blobs, so a partial list is not enough.
+	if len(candidates) > 0 && list.partial {
+		full, err := s.LoadList()
+		if err != nil {
+			return 0, 0, err
+		}
+		list = full
+	}
+
+	removed := 0
+	var freed int64
+	for _, key := range candidates {
+		if list.RefCount(key) > 0 {
+			continue
+		}
+		_, size, _ := s.locate(key)
+		if err := s.Remove(key); err != nil {
+			return removed, freed, err
+		}
+		removed++
+		freed += size
+	}
+	return removed, freed, nil
+}
+
+// RefCount returns how many versions across all files reference the blob
+// with the given key.
+func (l *List) RefCount(key string) int {
+	count := 0
+	for _, f := range l.Files {
+		for _, v := range f.Versions {
+			for _, k := range v.Objects() {
+				if k == key {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+// NewVersionID returns a random short version ID not yet used in the list.
+// Version IDs are independent of content so that saving identical content
+// twice yields two distinct versions sharing one blob.
+func (l *List) NewVersionID() string {
+	for {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		id := hex.EncodeToString(b)
+		if !l.hasVersionID(id) {
+			return id
+		}
+	}
+}
+
+func (l *List) hasVersionID(id string) bool {
+	for _, f := range l.Files {
+		for _, v := range f.Versions {
+			if v.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,388 @@
+package shadow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStorePut(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(NewLocalBackend(filepath.Join(tmpDir, ".shadow")))
+
+	srcPath := filepath.Join(tmpDir, "file.txt")
+	os.WriteFile(srcPath, []byte("hello"), 0644)
+
+	blob, err := store.Put(srcPath)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	hash := blob.Hash
+	if len(hash) != 64 {
+		t.Errorf("expected full SHA256 hash, got %s", hash)
+	}
+	if blob.Size != 5 || blob.StoredSize != 5 {
+		t.Errorf("expected size 5, got %d (stored %d)", blob.Size, blob.StoredSize)
+	}
+
+	expected := filepath.Join(tmpDir, ".shadow", "snapshots", hash[:2], hash[2:])
+	if _, err := os.Stat(expected); err != nil {
+		t.Errorf("expected blob at %s: %v", expected, err)
+	}
+	if !store.Has(hash) {
+		t.Error("blob should exist after Put")
+	}
+
+	again, err := store.Put(srcPath)
+	if err != nil {
+		t.Fatalf("second Put failed: %v", err)
+	}
+	if hash != again.Hash {
+		t.Error("same content should map to the same blob")
+	}
+}
+
+func TestStoreRestore(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(NewLocalBackend(filepath.Join(tmpDir, ".shadow")))
+
+	srcPath := filepath.Join(tmpDir, "file.txt")
+	os.WriteFile(srcPath, []byte("original"), 0644)
+	blob, _ := store.Put(srcPath)
+	entry := &FileEntry{Path: srcPath, Versions: []Version{{ID: "v1", Hash: blob.Hash}}}
+
+	os.WriteFile(srcPath, []byte("modified"), 0644)
+	if err := store.RestoreVersion(entry, "v1", srcPath); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(srcPath)
+	if string(content) != "original" {
+		t.Errorf("expected 'original', got %q", string(content))
+	}
+}
+
+func TestStorePutContext(t *testing.T) {
+	tmpDir := t.TempDir()
+	shadowPath := filepath.Join(tmpDir, ".shadow")
+	content := strings.Repeat("large file content ", 10000)
+	srcPath := filepath.Join(tmpDir, "dump.sql")
+	os.WriteFile(srcPath, []byte(content), 0644)
+
+	for name, backend := range map[string]Backend{"local": NewLocalBackend(shadowPath), "spooled": NewMemoryBackend()} {
+		t.Run(name, func(t *testing.T) {
+			store := NewStore(backend)
+			store.Codec = CodecGzip
+
+			var read int64
+			blob, err := store.PutContext(context.Background(), srcPath, func(n int64) { read = n })
+			if err != nil {
+				t.Fatalf("PutContext failed: %v", err)
+			}
+			if read != int64(len(content)) || blob.Size != read {
+				t.Errorf("expected %d bytes read, got %d (size %d)", len(content), read, blob.Size)
+			}
+			if sum := sha256.Sum256([]byte(content)); blob.Hash != hex.EncodeToString(sum[:]) {
+				t.Errorf("unexpected hash %s", blob.Hash)
+			}
+			if got, _ := store.readAll(blob.Hash); string(got) != content {
+				t.Error("stored content does not match")
+			}
+
+			again, err := store.PutContext(context.Background(), srcPath, nil)
+			if err != nil || again.Hash != blob.Hash || again.StoredSize != blob.StoredSize {
+				t.Errorf("second Put should reuse the blob: %+v, %v", again, err)
+			}
+		})
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(shadowPath, "snapshots"))
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Errorf("staged file left behind: %s", e.Name())
+		}
+	}
+}
+
+func TestStorePutContext_Cancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+	shadowPath := filepath.Join(tmpDir, ".shadow")
+	store := NewStore(NewLocalBackend(shadowPath))
+
+	srcPath := filepath.Join(tmpDir, "dump.sql")
+	os.WriteFile(srcPath, []byte(strings.Repeat("x", 1<<20)), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := store.PutContext(ctx, srcPath, func(int64) { cancel() })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	var files []string
+	filepath.WalkDir(shadowPath, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if len(files) != 0 {
+		t.Errorf("cancelled Put should leave nothing behind, found %v", files)
+	}
+}
+
+func TestStorePutLink(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(NewLocalBackend(filepath.Join(tmpDir, ".shadow")))
+
+	target := filepath.Join(tmpDir, "target.txt")
+	os.WriteFile(target, []byte("target content"), 0644)
+	link := filepath.Join(tmpDir, "link")
+	os.Symlink("target.txt", link)
+
+	blob, got, err := store.PutLink(link)
+	if err != nil {
+		t.Fatalf("PutLink failed: %v", err)
+	}
+	if got != "target.txt" || blob.Size != int64(len("target.txt")) || !store.Has(blob.Hash) {
+		t.Fatalf("unexpected link blob: %+v, target %q", blob, got)
+	}
+	entry := &FileEntry{Path: link, Versions: []Version{
+		{ID: "v2", Hash: blob.Hash, Link: got},
+	}}
+
+	// A regular file in place of the link is replaced by the link.
+	os.Remove(link)
+	os.WriteFile(link, []byte("regular"), 0644)
+	if err := store.RestoreVersion(entry, "v2", link); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if dest, err := os.Readlink(link); err != nil || dest != "target.txt" {
+		t.Fatalf("expected link to target.txt, got %q (%v)", dest, err)
+	}
+
+	// Restoring a regular version replaces the link instead of writing
+	// through it.
+	src := filepath.Join(tmpDir, "file.txt")
+	os.WriteFile(src, []byte("regular"), 0644)
+	file, _ := store.Put(src)
+	entry.Versions = append(entry.Versions, Version{ID: "v1", Hash: file.Hash})
+	if err := store.RestoreVersion(entry, "v1", link); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if info, _ := os.Lstat(link); info.Mode()&os.ModeSymlink != 0 {
+		t.Error("link should be replaced by a regular file")
+	}
+	if content, _ := os.ReadFile(target); string(content) != "target content" {
+		t.Errorf("target should be untouched, got %q", content)
+	}
+}
+
+func TestStorePut_Compressed(t *testing.T) {
+	for _, codec := range []string{CodecGzip, CodecZstd} {
+		t.Run(codec, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			store := NewStore(NewLocalBackend(filepath.Join(tmpDir, ".shadow")))
+			store.Codec = codec
+
+			content := strings.Repeat("key: value\n", 1000)
+			srcPath := filepath.Join(tmpDir, "config.yml")
+			os.WriteFile(srcPath, []byte(content), 0644)
+
+			blob, err := store.Put(srcPath)
+			if err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+
+			if blob.Codec != codec {
+				t.Errorf("expected codec %s, got %s", codec, blob.Codec)
+			}
+			if blob.Size != int64(len(content)) {
+				t.Errorf("expected logical size %d, got %d", len(content), blob.Size)
+			}
+			if blob.StoredSize >= blob.Size {
+				t.Errorf("expected compressed size below %d, got %d", blob.Size, blob.StoredSize)
+			}
+
+			entry := &FileEntry{Path: srcPath, Versions: []Version{{ID: "v1", Hash: blob.Hash}}}
+			os.Remove(srcPath)
+			if err := store.RestoreVersion(entry, "v1", srcPath); err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+			restored, _ := os.ReadFile(srcPath)
+			if string(restored) != content {
+				t.Error("restored content does not match original")
+			}
+		})
+	}
+}
+
+func TestStorePut_ReusesBlobUnderOtherCodec(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(NewLocalBackend(filepath.Join(tmpDir, ".shadow")))
+
+	srcPath := filepath.Join(tmpDir, "file.txt")
+	os.WriteFile(srcPath, []byte("uncompressed"), 0644)
+	first, _ := store.Put(srcPath)
+
+	store.Codec = CodecZstd
+	second, err := store.Put(srcPath)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if second.Codec != CodecNone {
+		t.Errorf("expected existing uncompressed blob to be reused, got codec %q", second.Codec)
+	}
+	if _, err := store.Backend().Stat(first.Hash + ".zst"); !os.IsNotExist(err) {
+		t.Error("blob should not be stored twice")
+	}
+
+	r, err := store.Open(first.Hash)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer r.Close()
+	content, _ := io.ReadAll(r)
+	if string(content) != "uncompressed" {
+		t.Errorf("expected 'uncompressed', got %q", string(content))
+	}
+}
+
+func TestStoreRelease_SharedBlob(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(NewLocalBackend(filepath.Join(tmpDir, ".shadow")))
+
+	srcPath := filepath.Join(tmpDir, "file.txt")
+	os.WriteFile(srcPath, []byte("shared"), 0644)
+	blob, _ := store.Put(srcPath)
+	hash := blob.Hash
+
+	list := &List{
+		Files: []FileEntry{
+			{Path: "/tmp/a.txt", Versions: []Version{{ID: "v1", Hash: hash}}},
+			{Path: "/tmp/b.txt", Versions: []Version{{ID: "v2", Hash: hash}}},
+		},
+	}
+
+	list.RemoveVersion("/tmp/a.txt", "v1")
+	removed, _, err := store.Release(list, hash)
+	if err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if removed != 0 || !store.Has(hash) {
+		t.Error("blob still referenced by another version should be kept")
+	}
+
+	list.RemoveVersion("/tmp/b.txt", "v2")
+	removed, freed, err := store.Release(list, hash, hash)
+	if err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if removed != 1 || store.Has(hash) {
+		t.Error("unreferenced blob should be removed")
+	}
+	if freed != blob.StoredSize {
+		t.Errorf("expected %d bytes freed, got %d", blob.StoredSize, freed)
+	}
+}
+
+func TestStoreMigrate(t *testing.T) {
+	tmpDir := t.TempDir()
+	shadowPath := filepath.Join(tmpDir, ".shadow")
+	store := NewStore(NewLocalBackend(shadowPath))
+
+	legacyPath := filepath.Join(shadowPath, "snapshots", "abcd1234")
+	os.MkdirAll(filepath.Dir(legacyPath), 0755)
+	os.WriteFile(legacyPath, []byte("legacy content"), 0644)
+	hash, _ := HashFile(legacyPath)
+
+	legacyList := `{"files":[
+		{"path":"/tmp/a.txt","versions":[{"id":"abcd1234","hash":"` + hash + `"}]},
+		{"path":"/tmp/b.txt","versions":[{"id":"abcd1234"}]}
+	]}`
+	os.WriteFile(filepath.Join(shadowPath, "list.json"), []byte(legacyList), 0644)
+
+	if !store.NeedsMigrate() {
+		t.Fatal("expected legacy repository to need migration")
+	}
+	steps, err := store.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if len(steps) != 3 {
+		t.Errorf("expected 3 migration steps, got %d", len(steps))
+	}
+
+	if _, err := os.Stat(legacyPath); !os.IsNotExist(err) {
+		t.Error("legacy snapshot should be moved")
+	}
+	if !store.Has(hash) {
+		t.Error("snapshot should be stored by content hash")
+	}
+	if _, err := os.Stat(filepath.Join(shadowPath, "list.json")); !os.IsNotExist(err) {
+		t.Error("list.json should be replaced by manifests")
+	}
+
+	list, err := store.LoadList()
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+	if len(list.Files) != 2 {
+		t.Fatalf("expected 2 files after migration, got %d", len(list.Files))
+	}
+	if list.FindFile("/tmp/b.txt").Versions[0].Hash != hash {
+		t.Error("missing version hash should be filled in")
+	}
+
+	if store.NeedsMigrate() {
+		t.Error("migrated repository should not need migration")
+	}
+	steps, err = store.Migrate()
+	if err != nil {
+		t.Fatalf("second Migrate failed: %v", err)
+	}
+	if len(steps) != 0 {
+		t.Error("migrating an up-to-date store should be a no-op")
+	}
+}
+
+func TestRefCount(t *testing.T) {
+	list := &List{
+		Files: []FileEntry{
+			{Path: "/tmp/a.txt", Versions: []Version{{ID: "v1", Hash: "h1"}, {ID: "v2", Hash: "h2"}}},
+			{Path: "/tmp/b.txt", Versions: []Version{{ID: "v3", Hash: "h1"}}},
+		},
+	}
+
+	if n := list.RefCount("h1"); n != 2 {
+		t.Errorf("expected 2 references to h1, got %d", n)
+	}
+	if n := list.RefCount("missing"); n != 0 {
+		t.Errorf("expected 0 references, got %d", n)
+	}
+}
+
+func TestNewVersionID(t *testing.T) {
+	list := &List{Files: []FileEntry{}}
+
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := list.NewVersionID()
+		if len(id) != 8 {
+			t.Fatalf("expected version ID length 8, got %d", len(id))
+		}
+		if seen[id] {
+			t.Fatalf("duplicate version ID %s", id)
+		}
+		seen[id] = true
+		list.AddVersion("/tmp/a.txt", Version{ID: id})
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package shadow
+
+import "errors"
+
+func readXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+func writeXattr(path, name string, value []byte) error {
+	return errors.New("extended attributes are not supported on this system")
+}
@@ -0,0 +1,216 @@
+package integration
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/chhlga/sh_adow/internal/shadow"
+)
+
+// shadowCommand returns a command running the shadow binary in home, with
+// HOME set to it.
+func shadowCommand(bin, home string, args ...string) *exec.Cmd {
+	cmd := exec.Command(bin, args...)
+	cmd.Env = append(os.Environ(), "HOME="+home)
+	cmd.Dir = home
+	return cmd
+}
+
+// runShadow runs the shadow binary and returns its standard output.
+func runShadow(t *testing.T, bin, home string, args ...string) string {
+	t.Helper()
+
+	cmd := shadowCommand(bin, home, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("shadow %s failed: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return string(out)
+}
+
+func TestList_TotalIsUpperBound(t *testing.T) {
+	bin := buildShadow(t)
+	tmpDir, _ := setupTestEnv(t)
+
+	configDir := filepath.Join(tmpDir, ".config", "sh_adow")
+	os.MkdirAll(configDir, 0755)
+	os.WriteFile(filepath.Join(configDir, "config.yml"), []byte("repo_path: \"~/backups/\"\n"), 0644)
+
+	content := []byte(strings.Repeat("shared line\n", 1000))
+	for _, name := range []string{"a.conf", "b.conf"} {
+		file := filepath.Join(tmpDir, name)
+		os.WriteFile(file, content, 0644)
+		runShadow(t, bin, tmpDir, "save", file)
+	}
+
+	// The files share their snapshot, which the total cannot tell without
+	// reading every manifest, so it is labelled as an upper bound.
+	out := runShadow(t, bin, tmpDir, "list")
+	if !regexp.MustCompile(`(?m)^Total: 2 versions, .*, stored at most `).MatchString(out) {
+		t.Errorf("expected the stored total to be labelled as an upper bound:\n%s", out)
+	}
+}
+
+func TestInodeIdentity_ReusedInode(t *testing.T) {
+	bin := buildShadow(t)
+	tmpDir, _ := setupTestEnv(t)
+
+	configDir := filepath.Join(tmpDir, ".config", "sh_adow")
+	os.MkdirAll(configDir, 0755)
+	os.WriteFile(filepath.Join(configDir, "config.yml"), []byte("repo_path: \"~/backups/\"\nidentity: \"inode\"\n"), 0644)
+
+	old := filepath.Join(tmpDir, "old.conf")
+	file := filepath.Join(tmpDir, "new.conf")
+	os.WriteFile(old, []byte("listen: 80\n"), 0644)
+	runShadow(t, bin, tmpDir, "save", old, "-t", "moved")
+
+	// A moved file keeps its history.
+	os.Rename(old, file)
+	if out := runShadow(t, bin, tmpDir, "list", file); !strings.Contains(out, `"moved"`) {
+		t.Errorf("expected the moved file to keep its history:\n%s", out)
+	}
+
+	// Writing in place keeps the inode, as a new file reusing it would: its
+	// content tells it apart from the file saved before.
+	os.WriteFile(file, []byte("unrelated: true\n"), 0644)
+	out, err := shadowCommand(bin, tmpDir, "list", file).CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "file not tracked") {
+		t.Errorf("expected a file reusing the inode not to adopt the history, got %v:\n%s", err, out)
+	}
+}
+
+// repoState returns the content of every file of the repository at dir but
+// its lock.
+func repoState(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	state := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() == "lock" {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		state[path] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to read repository: %v", err)
+	}
+	return state
+}
+
+func TestShow(t *testing.T) {
+	bin := buildShadow(t)
+	tmpDir, file := setupTestEnv(t)
+	shadowDir := filepath.Join(tmpDir, ".shadow")
+
+	os.WriteFile(file, []byte("version 1\n"), 0644)
+	runShadow(t, bin, tmpDir, "save", file, "-t", "first")
+	os.WriteFile(file, []byte("version 2\n"), 0644)
+	runShadow(t, bin, tmpDir, "save", file)
+
+	list, err := shadow.LoadList(shadowDir)
+	if err != nil || len(list.Files) != 1 || len(list.Files[0].Versions) != 2 {
+		t.Fatalf("unexpected list: %+v, %v", list, err)
+	}
+	latest, first := list.Files[0].Versions[0], list.Files[0].Versions[1]
+
+	// The latest version by default.
+	if out := runShadow(t, bin, tmpDir, "show", file); out != "version 2\n" {
+		t.Errorf("expected the latest version, got %q", out)
+	}
+	if out := runShadow(t, bin, tmpDir, "show", file, first.ID); out != "version 1\n" {
+		t.Errorf("expected the first version, got %q", out)
+	}
+
+	out := runShadow(t, bin, tmpDir, "show", file, "--meta")
+	for _, want := range []string{"Version:   " + latest.ID, "Hash:      " + latest.Hash, "Size:      10 B"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in metadata:\n%s", want, out)
+		}
+	}
+	if out := runShadow(t, bin, tmpDir, "show", file, first.ID, "--meta"); !strings.Contains(out, "Tags:      first") {
+		t.Errorf("expected the tags of the first version:\n%s", out)
+	}
+
+	// Writing a version elsewhere changes neither the tracked file nor the
+	// repository, and the tracked file itself is refused.
+	os.WriteFile(file, []byte("current\n"), 0644)
+	before := repoState(t, shadowDir)
+
+	if out, err := shadowCommand(bin, tmpDir, "show", file, first.ID, "-o", "./test.txt").CombinedOutput(); err == nil {
+		t.Errorf("expected writing over the tracked file to fail:\n%s", out)
+	}
+	output := filepath.Join(tmpDir, "out", "old.txt")
+	os.MkdirAll(filepath.Dir(output), 0755)
+	runShadow(t, bin, tmpDir, "show", file, first.ID, "-o", output)
+
+	if data, _ := os.ReadFile(output); string(data) != "version 1\n" {
+		t.Errorf("expected the first version in %s, got %q", output, data)
+	}
+	if info, err := os.Stat(output); err != nil || !info.ModTime().Equal(first.Meta.ModTime) {
+		t.Errorf("expected the modification time of the version, got %v", info)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "current\n" {
+		t.Errorf("tracked file changed: %q", data)
+	}
+	if after := repoState(t, shadowDir); !reflect.DeepEqual(before, after) {
+		t.Error("repository changed by show --output")
+	}
+
+	// The content is checked against its hash as it is printed.
+	blob := filepath.Join(shadowDir, "snapshots", latest.Hash[:2], latest.Hash[2:])
+	if err := os.WriteFile(blob, []byte("tampered\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := shadowCommand(bin, tmpDir, "show", file)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if _, err := cmd.Output(); err == nil || !strings.Contains(stderr.String(), shadow.ErrCorrupt.Error()) {
+		t.Errorf("expected a corrupt version to fail, got %v:\n%s", err, stderr.String())
+	}
+}
+
+func TestFollow_RestoreKeepsLink(t *testing.T) {
+	bin := buildShadow(t)
+	tmpDir, _ := setupTestEnv(t)
+
+	target := filepath.Join(tmpDir, "target.conf")
+	link := filepath.Join(tmpDir, "link.conf")
+	os.WriteFile(target, []byte("version 1\n"), 0644)
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	runShadow(t, bin, tmpDir, "save", link, "-L", "-t", "first")
+
+	list, err := shadow.LoadList(filepath.Join(tmpDir, ".shadow"))
+	if err != nil || len(list.Files) != 1 || len(list.Files[0].Versions) != 1 {
+		t.Fatalf("unexpected list: %+v, %v", list, err)
+	}
+	id := list.Files[0].Versions[0].ID
+
+	if out := runShadow(t, bin, tmpDir, "list", link, "-L"); !strings.Contains(out, id) {
+		t.Errorf("expected the history of the target:\n%s", out)
+	}
+	if out := runShadow(t, bin, tmpDir, "show", link, "-L"); out != "version 1\n" {
+		t.Errorf("expected the saved version, got %q", out)
+	}
+
+	os.WriteFile(target, []byte("version 2\n"), 0644)
+	runShadow(t, bin, tmpDir, "restore", link, id, "-L", "--no-save")
+
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected the link to stay a symlink, got %v, %v", info, err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "version 1\n" {
+		t.Errorf("expected the target to be restored, got %q", data)
+	}
+}
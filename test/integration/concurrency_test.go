@@ -0,0 +1,80 @@
+package integration
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/chhlga/sh_adow/internal/shadow"
+)
+
+// buildShadow compiles the shadow binary for tests that need separate
+// processes.
+func buildShadow(t *testing.T) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	bin := filepath.Join(t.TempDir(), "shadow")
+	out, err := exec.Command("go", "build", "-o", bin, "github.com/chhlga/sh_adow").CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to build shadow: %v\n%s", err, out)
+	}
+	return bin
+}
+
+func TestConcurrentSaves_NoLostVersions(t *testing.T) {
+	bin := buildShadow(t)
+	tmpDir, _ := setupTestEnv(t)
+
+	configDir := filepath.Join(tmpDir, ".config", "sh_adow")
+	os.MkdirAll(configDir, 0755)
+	os.WriteFile(filepath.Join(configDir, "config.yml"), []byte("repo_path: \"~/backups/\"\n"), 0644)
+
+	const workers, saves = 6, 4
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		dir := filepath.Join(tmpDir, fmt.Sprintf("project%d", w))
+		os.MkdirAll(dir, 0755)
+		file := filepath.Join(dir, "config.yml")
+
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < saves; i++ {
+				os.WriteFile(file, []byte(fmt.Sprintf("worker: %d\nsave: %d\n", w, i)), 0644)
+				cmd := exec.Command(bin, "save", file, "-t", "concurrent", "-n", fmt.Sprintf("save %d", i))
+				cmd.Env = append(os.Environ(), "HOME="+tmpDir)
+				if out, err := cmd.CombinedOutput(); err != nil {
+					errs <- fmt.Errorf("worker %d: %v\n%s", w, err, out)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	list, err := shadow.LoadList(filepath.Join(tmpDir, "backups", ".shadow"))
+	if err != nil {
+		t.Fatalf("failed to load list: %v", err)
+	}
+	if len(list.Files) != workers {
+		t.Errorf("expected %d tracked files, got %d", workers, len(list.Files))
+	}
+	for _, f := range list.Files {
+		if len(f.Versions) != saves {
+			t.Errorf("%s: expected %d versions, got %d", f.Path, saves, len(f.Versions))
+		}
+	}
+}
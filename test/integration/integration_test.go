@@ -36,17 +36,19 @@ func TestWorkflow_SaveListRestore(t *testing.T) {
 	os.WriteFile(testFile, []byte("version 1"), 0644)
 
 	list := &shadow.List{Files: []shadow.FileEntry{}}
-	content, _ := os.ReadFile(testFile)
-	versionID := shadow.GenerateVersionID(content)
-
-	snapshotPath := filepath.Join(shadowPath, "snapshots", versionID)
-	shadow.CopyFile(testFile, snapshotPath)
+	store := shadow.NewStore(shadow.NewLocalBackend(shadowPath))
+	blob, err := store.Put(testFile)
+	if err != nil {
+		t.Fatalf("failed to store snapshot: %v", err)
+	}
 
+	versionID := list.NewVersionID()
 	version := shadow.Version{
 		ID:    versionID,
 		Tags:  []string{"v1"},
 		Notes: "first version",
-		Size:  9,
+		Size:  blob.Size,
+		Hash:  blob.Hash,
 	}
 
 	absPath, _ := filepath.Abs(testFile)
@@ -73,7 +75,7 @@ func TestWorkflow_SaveListRestore(t *testing.T) {
 
 	os.WriteFile(testFile, []byte("version 2 - modified"), 0644)
 
-	if err := shadow.CopyFile(snapshotPath, testFile); err != nil {
+	if err := store.RestoreVersion(entry, versionID, testFile); err != nil {
 		t.Fatalf("failed to restore: %v", err)
 	}
 
@@ -91,6 +93,7 @@ func TestWorkflow_MultipleVersions(t *testing.T) {
 	repo.EnsureShadowDir(shadowPath)
 
 	list := &shadow.List{Files: []shadow.FileEntry{}}
+	store := shadow.NewStore(shadow.NewLocalBackend(shadowPath))
 	absPath, _ := filepath.Abs(testFile)
 
 	versions := []string{"content v1", "content v2", "content v3"}
@@ -98,17 +101,14 @@ func TestWorkflow_MultipleVersions(t *testing.T) {
 	for i, content := range versions {
 		os.WriteFile(testFile, []byte(content), 0644)
 
-		fileContent, _ := os.ReadFile(testFile)
-		versionID := shadow.GenerateVersionID(fileContent)
-
-		snapshotPath := filepath.Join(shadowPath, "snapshots", versionID)
-		shadow.CopyFile(testFile, snapshotPath)
+		blob, _ := store.Put(testFile)
 
 		version := shadow.Version{
-			ID:    versionID,
+			ID:    list.NewVersionID(),
 			Tags:  []string{string(rune('A' + i))},
 			Notes: content,
-			Size:  int64(len(content)),
+			Size:  blob.Size,
+			Hash:  blob.Hash,
 		}
 
 		list.AddVersion(absPath, version)
@@ -136,21 +136,21 @@ func TestWorkflow_DeleteVersion(t *testing.T) {
 	repo.EnsureShadowDir(shadowPath)
 
 	list := &shadow.List{Files: []shadow.FileEntry{}}
+	store := shadow.NewStore(shadow.NewLocalBackend(shadowPath))
 	absPath, _ := filepath.Abs(testFile)
 
 	content := "test content"
 	os.WriteFile(testFile, []byte(content), 0644)
 
-	fileContent, _ := os.ReadFile(testFile)
-	versionID := shadow.GenerateVersionID(fileContent)
-
-	snapshotPath := filepath.Join(shadowPath, "snapshots", versionID)
-	shadow.CopyFile(testFile, snapshotPath)
+	blob, _ := store.Put(testFile)
+	hash := blob.Hash
+	versionID := list.NewVersionID()
 
 	version := shadow.Version{
 		ID:   versionID,
 		Tags: []string{"deleteme"},
-		Size: int64(len(content)),
+		Size: blob.Size,
+		Hash: hash,
 	}
 
 	list.AddVersion(absPath, version)
@@ -163,9 +163,9 @@ func TestWorkflow_DeleteVersion(t *testing.T) {
 
 	list.Save(shadowPath)
 
-	os.Remove(snapshotPath)
+	store.Release(list, hash)
 
-	if _, err := os.Stat(snapshotPath); !os.IsNotExist(err) {
+	if store.Has(hash) {
 		t.Error("snapshot file should be deleted")
 	}
 
@@ -213,11 +213,13 @@ func TestWorkflow_HashConsistency(t *testing.T) {
 		t.Error("same file should produce same hash")
 	}
 
-	versionID1 := shadow.GenerateVersionID([]byte(content))
-	versionID2 := shadow.GenerateVersionID([]byte(content))
+	list := &shadow.List{Files: []shadow.FileEntry{}}
+	versionID1 := list.NewVersionID()
+	list.AddVersion(testFile, shadow.Version{ID: versionID1, Hash: hash1})
+	versionID2 := list.NewVersionID()
 
-	if versionID1 != versionID2 {
-		t.Error("same content should produce same version ID")
+	if versionID1 == versionID2 {
+		t.Error("saving the same content twice should produce distinct version IDs")
 	}
 
 	os.WriteFile(testFile, []byte("different content"), 0644)
@@ -241,18 +243,16 @@ func TestWorkflow_ConcurrentFiles(t *testing.T) {
 	repo.EnsureShadowDir(shadowPath)
 
 	list := &shadow.List{Files: []shadow.FileEntry{}}
+	store := shadow.NewStore(shadow.NewLocalBackend(shadowPath))
 
 	for _, file := range []string{file1, file2} {
-		content, _ := os.ReadFile(file)
-		versionID := shadow.GenerateVersionID(content)
-
-		snapshotPath := filepath.Join(shadowPath, "snapshots", versionID)
-		shadow.CopyFile(file, snapshotPath)
+		blob, _ := store.Put(file)
 
 		absPath, _ := filepath.Abs(file)
 		version := shadow.Version{
-			ID:   versionID,
-			Size: int64(len(content)),
+			ID:   list.NewVersionID(),
+			Size: blob.Size,
+			Hash: blob.Hash,
 		}
 		list.AddVersion(absPath, version)
 	}